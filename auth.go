@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm"
+	"github.com/allape/openkvm/kvm/audit"
+	"github.com/allape/openkvm/kvm/lockout"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+const UserContextKey = "openkvm.user"
+
+// BasicAuth authenticates the request against users of VNC,
+// everyone is admin if no user is configured.
+// Failures are counted by guard per client IP, and logged to auditor.
+func BasicAuth(users []config.User, guard *lockout.Guard, auditor audit.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if len(users) == 0 {
+			context.Set(UserContextKey, config.User{Permissions: config.Permissions{config.PermissionAdmin}})
+			return
+		}
+
+		ip := context.ClientIP()
+		if wait := guard.Blocked(ip); wait > 0 {
+			context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			context.String(http.StatusTooManyRequests, "too many authentication failures")
+			context.Abort()
+			return
+		}
+
+		username, password, ok := context.Request.BasicAuth()
+		i := -1
+		if ok {
+			i = slices.IndexFunc(users, func(u config.User) bool {
+				return u.Username == username && u.VerifyHTTPPassword(password)
+			})
+			// requests without credentials are NOT failures, browsers send them before prompting
+			if i == -1 {
+				event := AuditEvent(context, audit.AuthFail)
+				event.Username = username
+				event.Reason = "Username or password is incorrect"
+				auditor.Log(event)
+
+				if guard.Fail(ip) {
+					event.Type = audit.Lockout
+					event.Reason = kvm.TooManyAuthFailuresReason
+					auditor.Log(event)
+				}
+			}
+		}
+		if i == -1 {
+			context.Header("WWW-Authenticate", `Basic realm="Authorization Required"`)
+			context.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		guard.Succeed(ip)
+
+		context.Set(UserContextKey, users[i])
+	}
+}
+
+// AuditEvent creates an event of request, with the user set by BasicAuth
+func AuditEvent(context *gin.Context, t audit.EventType) audit.Event {
+	event := audit.Event{
+		Type:       t,
+		Via:        audit.HTTP,
+		RemoteAddr: context.ClientIP(),
+	}
+	if user, ok := context.Get(UserContextKey); ok {
+		event.Username = user.(config.User).Username
+	}
+	return event
+}
+
+// RequirePermission aborts the request if the user set by BasicAuth does NOT have permission p
+func RequirePermission(p config.Permission) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user, ok := context.Get(UserContextKey)
+		if !ok || !user.(config.User).Can(p) {
+			context.String(http.StatusForbidden, "permission denied")
+			context.Abort()
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/allape/openkvm/crypto/des"
+	"github.com/allape/openkvm/crypto/password"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+)
+
+var EmptyPassword = errors.New("password can NOT be empty")
+
+// RunCommand runs the sub command named by args[0], ok is false if it is not a sub command.
+//
+//	openkvm passwd [-view-only] ~/.vnc/passwd
+//	openkvm hash
+func RunCommand(args []string) (ok bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	switch args[0] {
+	case "passwd":
+		flags := flag.NewFlagSet("passwd", flag.ContinueOnError)
+		viewOnly := flags.Bool("view-only", false, "also ask for a view-only password")
+		flags.Usage = func() {
+			_, _ = fmt.Fprintln(flags.Output(), "Usage: openkvm passwd [-view-only] <file>")
+			flags.PrintDefaults()
+		}
+		err = flags.Parse(args[1:])
+		if err != nil {
+			return true, err
+		} else if flags.NArg() != 1 {
+			flags.Usage()
+			return true, errors.New("missing passwd file")
+		}
+
+		passwords := make([]string, 0, 2)
+
+		p, err := readPassword(stdin, "Password: ")
+		if err != nil {
+			return true, err
+		}
+		passwords = append(passwords, p)
+
+		if *viewOnly {
+			p, err = readPassword(stdin, "View-only password: ")
+			if err != nil {
+				return true, err
+			}
+			passwords = append(passwords, p)
+		}
+
+		for _, p := range passwords {
+			if len(p) > des.BlockSize {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: only the first %d characters are used by VNC auth\n", des.BlockSize)
+				break
+			}
+		}
+
+		return true, des.WritePasswdFile(flags.Arg(0), passwords...)
+	case "hash":
+		p, err := readPassword(stdin, "Password: ")
+		if err != nil {
+			return true, err
+		}
+
+		hash, err := password.Hash(p)
+		if err != nil {
+			return true, err
+		}
+
+		fmt.Println(hash)
+
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// readPassword reads a line from stdin, it is NOT echoed if stdin is a terminal
+func readPassword(stdin *bufio.Reader, prompt string) (string, error) {
+	_, _ = fmt.Fprint(os.Stderr, prompt)
+
+	var line string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		bs, err := term.ReadPassword(fd)
+		// the newline typed is NOT echoed either
+		_, _ = fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		line = string(bs)
+	} else {
+		// piped input
+		var err error
+		line, err = stdin.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", EmptyPassword
+	}
+
+	return line, nil
+}
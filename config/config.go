@@ -18,6 +18,24 @@ const (
 	VideoDummyDevice VideoDriverType = "dummy"
 )
 
+type CursorShape string
+
+const (
+	CursorArrow CursorShape = "arrow"
+	CursorDot   CursorShape = "dot"
+	CursorNone  CursorShape = "none"
+)
+
+type VideoEncodingType string
+
+const (
+	VideoEncodingRaw      VideoEncodingType = "raw"
+	VideoEncodingHextile  VideoEncodingType = "hextile"
+	VideoEncodingZRLE     VideoEncodingType = "zrle"
+	VideoEncodingTight    VideoEncodingType = "tight"
+	VideoEncodingTightPNG VideoEncodingType = "tightpng"
+)
+
 type KeyboardDriverType string
 
 const (
@@ -52,6 +70,16 @@ type Websocket struct {
 	Path    string `toml:"path"`
 	Cors    bool   `toml:"cors"`
 	Timeout int    `toml:"timeout"` // in sec
+
+	// TLS serves HTTPS and WSS on Addr
+	TLS bool `toml:"tls"`
+	// TLSCert and TLSKey are paths to PEM files, a self-signed certificate is generated if they do NOT exist
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+	// RedirectAddr listens for plain HTTP and redirects to HTTPS, empty means disabled
+	RedirectAddr string `toml:"redirect_addr"`
+	// TrustedProxies are IPs or CIDRs of reverse proxies, whose X-Forwarded-For is used as client IP, empty means none
+	TrustedProxies []string `toml:"trusted_proxies"`
 }
 
 type Video struct {
@@ -64,6 +92,22 @@ type Video struct {
 	Quality       int             `toml:"quality"`
 	SliceCount    SliceCount      `toml:"slice_count"`
 	Ext           ExtMap          `toml:"ext"`
+
+	// Encodings
+	// Enabled encodings in the preferred order of the server.
+	// If it is empty, all encodings are enabled and the order of the client is respected.
+	Encodings []VideoEncodingType `toml:"encodings"`
+
+	// DisableCopyRect
+	// Moved regions, like scrolling, are sent as CopyRect if VNC client supports it.
+	// Set it to true to save CPU on low-end SBC.
+	DisableCopyRect bool `toml:"disable_copy_rect"`
+
+	// Resolutions
+	// Sizes allowed to be requested by VNC client, in format of WIDTHxHEIGHT.
+	// If it is empty, any size is allowed.
+	// Only works when `src` contains `{width}` and `{height}`.
+	Resolutions []Resolution `toml:"resolutions"`
 }
 
 type Keyboard struct {
@@ -84,6 +128,14 @@ type Mouse struct {
 	CursorXScale float64 `toml:"cursor_x_scale"`
 	// CursorYScale: see CursorXScale
 	CursorYScale float64 `toml:"cursor_y_scale"`
+
+	// Cursor
+	// Shape of the local cursor drawn by VNC client, `arrow`, `dot`, `none` or path to a PNG file.
+	// `none` leaves it to VNC client, which may draw nothing.
+	// Empty means `arrow`.
+	Cursor CursorShape `toml:"cursor"`
+	// CursorHotspot the clicking point of the PNG cursor, in [x, y]
+	CursorHotspot [2]int `toml:"cursor_hotspot"`
 }
 
 type Button struct {
@@ -101,10 +153,80 @@ type Clipboard struct {
 	Ext  SerialPortExt       `toml:"ext"`
 }
 
+type SharePolicy string
+
+const (
+	// ShareDisconnect a client asks for exclusive access disconnects the others when it connects, shared clients can connect after it.
+	ShareDisconnect SharePolicy = "disconnect"
+	// ShareRefuse a client asks for exclusive access is refused if there are other clients.
+	ShareRefuse SharePolicy = "refuse"
+	// ShareAlways the shared-flag is ignored, all clients are shared.
+	ShareAlways SharePolicy = "always"
+)
+
 type VNC struct {
-	Path     string `toml:"path"`
-	Username string `toml:"username"`
-	Password string `toml:"password"`
+	Path string `toml:"path"`
+
+	// Listen address of native RFB over TCP, for example `:5900`, empty means disabled
+	Listen string `toml:"listen"`
+
+	// TLS enables VeNCrypt security type
+	TLS bool `toml:"tls"`
+	// TLSOnly disables security types without TLS, noVNC can NOT connect then
+	TLSOnly bool `toml:"tls_only"`
+	// TLSCert and TLSKey are paths to PEM files, a self-signed certificate is generated if they do NOT exist
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+
+	// Username, Password, PasswordHash and PasswdFile are the admin user, kept for compatibility, use Users instead
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+	PasswordHash string `toml:"password_hash"`
+	// PasswdFile the view-only password in it is also used as a view-only user
+	PasswdFile string `toml:"passwd_file"`
+
+	passwdFilePasswords []string
+
+	Users []User `toml:"users"`
+
+	// MaxAuthFailures of VNC and HTTP auth in a row before an IP is locked out, 0 means 5
+	MaxAuthFailures int `toml:"max_auth_failures"`
+	// AuthLockout in seconds, 0 means 300
+	AuthLockout int `toml:"auth_lockout"`
+
+	// IdleTimeout disconnects clients without keyboard, mouse or clipboard input for this many seconds, 0 means disabled
+	IdleTimeout int `toml:"idle_timeout"`
+	// IdleWarning a Bell is sent this many seconds before disconnecting by IdleTimeout or MaxSession, 0 means 60
+	IdleWarning int `toml:"idle_warning"`
+	// MaxSession disconnects clients connected for this many seconds, 0 means disabled
+	MaxSession int `toml:"max_session"`
+
+	// ShareSecret signs share links of guests, empty means a random one, links are invalidated on restart then
+	ShareSecret string `toml:"share_secret"`
+	// ShareMaxTTL the longest lifetime of share links in seconds, 0 means 1 day
+	ShareMaxTTL int `toml:"share_max_ttl"`
+	// ShareBaseURL where guests open noVNC, like `https://kvm.example.com`, empty means the scheme and host of the request
+	ShareBaseURL string `toml:"share_base_url"`
+
+	// SharePolicy what to do when a client connects with shared-flag = 0, empty means `disconnect`.
+	SharePolicy SharePolicy `toml:"share_policy"`
+
+	// ControlTimeout
+	// Only one client can control keyboard and mouse, others are view-only.
+	// After the controller is idle for this many seconds, others can take control by input.
+	// 0 means 30 seconds.
+	ControlTimeout int `toml:"control_timeout"`
+}
+
+type Audit struct {
+	// Path of audit log in JSON lines, empty means disabled
+	Path string `toml:"path"`
+	// MaxSize in MB before the log is rotated, 0 means no rotation
+	MaxSize int `toml:"max_size"`
+	// MaxBackups count of rotated logs to keep, 0 means keeping all of them
+	MaxBackups int `toml:"max_backups"`
+	// ClipboardText records the full clipboard text, otherwise only the length is recorded
+	ClipboardText bool `toml:"clipboard_text"`
 }
 
 type Config struct {
@@ -115,13 +237,19 @@ type Config struct {
 	Button    Button    `toml:"button"`
 	Clipboard Clipboard `toml:"clipboard"`
 	VNC       VNC       `toml:"vnc"`
+	Audit     Audit     `toml:"audit"`
 }
 
-func GetConfig() (Config, error) {
-	configFile := DefaultConfigPath
+// Path returns the path of config file, which is the first argument or DefaultConfigPath
+func Path() string {
 	if len(os.Args) > 1 {
-		configFile = os.Args[1]
+		return os.Args[1]
 	}
+	return DefaultConfigPath
+}
+
+func GetConfig() (Config, error) {
+	configFile := Path()
 
 	l.Info().Println("reading config file:", configFile)
 
@@ -167,6 +295,11 @@ func GetConfig() (Config, error) {
 		return config, err
 	}
 
+	err = config.VNC.loadCredentials()
+	if err != nil {
+		return config, err
+	}
+
 	l.Debug().Println("use config:", config)
 
 	return config, nil
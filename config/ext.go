@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os/exec"
 	"strconv"
+	"strings"
 )
 
 type VideoSrc []string
@@ -13,8 +14,40 @@ func (s VideoSrc) Empty() bool {
 	return len(s) == 0
 }
 
+// placeholders in video src and setup commands, they are replaced with the current size
+const (
+	WidthPlaceholder  = "{width}"
+	HeightPlaceholder = "{height}"
+)
+
+func replaceSize(args []string, width, height int) []string {
+	replacer := strings.NewReplacer(
+		WidthPlaceholder, strconv.Itoa(width),
+		HeightPlaceholder, strconv.Itoa(height),
+	)
+	replaced := make([]string, len(args))
+	for i, arg := range args {
+		replaced[i] = replacer.Replace(arg)
+	}
+	return replaced
+}
+
 type VideoShellSrc VideoSrc
 
+// Resizable returns true if the command contains size placeholders
+func (s VideoShellSrc) Resizable() bool {
+	for _, arg := range s {
+		if strings.Contains(arg, WidthPlaceholder) || strings.Contains(arg, HeightPlaceholder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s VideoShellSrc) WithSize(width, height int) VideoShellSrc {
+	return replaceSize(s, width, height)
+}
+
 func (s VideoShellSrc) Empty() bool {
 	return VideoSrc(s).Empty()
 }
@@ -29,6 +62,10 @@ func (s VideoShellSrc) ToCommand() (*exec.Cmd, error) {
 
 type SetupCommand []string
 
+func (s SetupCommand) WithSize(width, height int) SetupCommand {
+	return replaceSize(s, width, height)
+}
+
 func (s SetupCommand) ToCommand() (*exec.Cmd, error) {
 	if len(s) == 0 {
 		return nil, nil
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/allape/openkvm/crypto/des"
+	"github.com/allape/openkvm/crypto/password"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+type Permission string
+
+const (
+	// PermissionView watch the screen, every user needs it to connect
+	PermissionView Permission = "view"
+	// PermissionInput control keyboard and mouse
+	PermissionInput Permission = "input"
+	// PermissionClipboard paste into the target
+	PermissionClipboard Permission = "clipboard"
+	// PermissionPower press power and reset buttons
+	PermissionPower Permission = "power"
+	// PermissionAdmin has all permissions above, and can give control to anyone
+	PermissionAdmin Permission = "admin"
+)
+
+type Permissions []Permission
+
+func (ps Permissions) Has(p Permission) bool {
+	return slices.Contains(ps, p) || slices.Contains(ps, PermissionAdmin)
+}
+
+type User struct {
+	Username string `toml:"username"`
+	// Password in plaintext, used by both VNC and HTTP
+	Password string `toml:"password"`
+	// PasswordHash bcrypt or argon2id hash, used by HTTP and VNC auth with username,
+	// it can NOT be used by std VNC auth, which needs the password in plaintext
+	PasswordHash string `toml:"password_hash"`
+	// PasswdFile VNC passwd file created by `vncpasswd` or `openkvm passwd`, used by VNC auth
+	PasswdFile string `toml:"passwd_file"`
+	// Permissions empty means `view` only
+	Permissions Permissions `toml:"permissions"`
+
+	// vncPassword is read from PasswdFile
+	vncPassword string
+}
+
+func (u User) Can(p Permission) bool {
+	if len(u.Permissions) == 0 {
+		return p == PermissionView
+	}
+	return u.Permissions.Has(p)
+}
+
+// VNCPassword returns the password for std VNC auth, empty if the user can NOT use it
+func (u User) VNCPassword() string {
+	if u.Password != "" {
+		return u.Password
+	}
+	return u.vncPassword
+}
+
+// VerifyPassword checks password against all credentials of the user, in constant time
+func (u User) VerifyPassword(p string) bool {
+	if u.PasswordHash != "" && password.Verify(u.PasswordHash, p) {
+		return true
+	}
+	return equal(u.VNCPassword(), p)
+}
+
+// VerifyHTTPPassword checks password against PasswordHash, or Password if no hash is set.
+// VNC passwd file is NOT used for HTTP.
+func (u User) VerifyHTTPPassword(p string) bool {
+	if u.PasswordHash != "" {
+		return password.Verify(u.PasswordHash, p)
+	}
+	return equal(u.Password, p)
+}
+
+func (u User) hasCredential() bool {
+	return u.Password != "" || u.PasswordHash != "" || u.vncPassword != ""
+}
+
+func (u *User) loadCredentials() ([]string, error) {
+	if u.PasswordHash != "" {
+		err := password.Check(u.PasswordHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if u.PasswdFile == "" {
+		return nil, nil
+	}
+
+	passwords, err := des.ReadPasswdFile(expandHome(u.PasswdFile))
+	if err != nil {
+		return nil, err
+	}
+	u.vncPassword = passwords[0]
+
+	return passwords, nil
+}
+
+// AllUsers returns Users, and the admin user if any credential of it is set
+func (v VNC) AllUsers() []User {
+	users := slices.Clone(v.Users)
+
+	admin := User{
+		Username:     v.Username,
+		Password:     v.Password,
+		PasswordHash: v.PasswordHash,
+		PasswdFile:   v.PasswdFile,
+		Permissions:  Permissions{PermissionAdmin},
+	}
+	if len(v.passwdFilePasswords) > 0 {
+		admin.vncPassword = v.passwdFilePasswords[0]
+	}
+	if admin.hasCredential() {
+		users = append(users, admin)
+	}
+
+	// the second password of vncpasswd is the view-only one
+	if len(v.passwdFilePasswords) > 1 && v.passwdFilePasswords[1] != "" {
+		users = append(users, User{
+			vncPassword: v.passwdFilePasswords[1],
+			Permissions: Permissions{PermissionView},
+		})
+	}
+
+	return users
+}
+
+func (v *VNC) loadCredentials() error {
+	for i := range v.Users {
+		_, err := v.Users[i].loadCredentials()
+		if err != nil {
+			return err
+		}
+	}
+
+	admin := User{PasswordHash: v.PasswordHash, PasswdFile: v.PasswdFile}
+	passwords, err := admin.loadCredentials()
+	if err != nil {
+		return err
+	}
+	v.passwdFilePasswords = passwords
+
+	return v.checkVNCPasswords()
+}
+
+// checkVNCPasswords rejects users who can NOT be told apart by std VNC auth,
+// which has no username, and DES takes only the first 8 bytes of password.
+func (v VNC) checkVNCPasswords() error {
+	// first 8 bytes of password -> username
+	seen := make(map[string]string)
+	for _, user := range v.AllUsers() {
+		p := user.VNCPassword()
+		if p == "" {
+			continue
+		}
+		name := user.Username
+		if name == "" {
+			// admin or view-only user of [vnc]
+			name = fmt.Sprintf("[vnc] %v", user.Permissions)
+		}
+		key := p[:min(len(p), des.BlockSize)]
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("VNC passwords of users %q and %q are the same in the first %d bytes", other, name, des.BlockSize)
+		}
+		seen[key] = name
+	}
+	return nil
+}
+
+// equal compares in constant time, empty expected never matches
+func equal(expected, actual string) bool {
+	if expected == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+func expandHome(filename string) string {
+	if !strings.HasPrefix(filename, "~/") {
+		return filename
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filename
+	}
+	return path.Join(home, filename[2:])
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"image"
 )
 
@@ -20,4 +21,22 @@ type (
 		Y     uint64
 		Frame Frame
 	}
+
+	// CopyRect copies the area at Src to Rect, both are in the framebuffer of VNC client
+	CopyRect struct {
+		Rect image.Rectangle
+		Src  image.Point
+	}
 )
+
+// Resolution in format of WIDTHxHEIGHT, like 1280x720
+type Resolution string
+
+func (r Resolution) Size() (Size, error) {
+	var size Size
+	_, err := fmt.Sscanf(string(r), "%dx%d", &size.X, &size.Y)
+	if err != nil {
+		return size, fmt.Errorf("invalid resolution %q: %w", r, err)
+	}
+	return size, nil
+}
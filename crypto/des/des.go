@@ -226,15 +226,24 @@ var ErrInvalidLength = errors.New("invalid length")
 const BlockSize = 8
 
 type DES struct {
-	keys []uint32
+	keys        []uint32
+	decryptKeys []uint32
 }
 
 func (d *DES) Encrypt(dst, src []byte) error {
+	return d.crypt(d.keys, dst, src)
+}
+
+func (d *DES) Decrypt(dst, src []byte) error {
+	return d.crypt(d.decryptKeys, dst, src)
+}
+
+func (d *DES) crypt(keys []uint32, dst, src []byte) error {
 	if len(src)%8 != 0 {
 		return ErrInvalidLength
 	}
 	for i := 0; i < len(src); i += 8 {
-		err := d.encode(dst[i:i+8], src[i:i+8])
+		err := d.encode(keys, dst[i:i+8], src[i:i+8])
 		if err != nil {
 			return err
 		}
@@ -242,7 +251,7 @@ func (d *DES) Encrypt(dst, src []byte) error {
 	return nil
 }
 
-func (d *DES) encode(dst, src []byte) error {
+func (d *DES) encode(keys []uint32, dst, src []byte) error {
 	if len(dst) != 8 {
 		return ErrInvalidLength
 	}
@@ -294,26 +303,26 @@ func (d *DES) encode(dst, src []byte) error {
 
 	keysi := 0
 	for i := 0; i < 8; i += 1 {
-		x = ((r << 28) | (r >> 4)) ^ d.keys[keysi]
+		x = ((r << 28) | (r >> 4)) ^ keys[keysi]
 		keysi += 1
 		fval := SP7[x&0x3f]
 		fval |= SP5[(x>>8)&0x3f]
 		fval |= SP3[(x>>16)&0x3f]
 		fval |= SP1[(x>>24)&0x3f]
-		x = r ^ d.keys[keysi]
+		x = r ^ keys[keysi]
 		keysi += 1
 		fval |= SP8[x&0x3f]
 		fval |= SP6[(x>>8)&0x3f]
 		fval |= SP4[(x>>16)&0x3f]
 		fval |= SP2[(x>>24)&0x3f]
 		l ^= fval
-		x = ((l << 28) | (l >> 4)) ^ d.keys[keysi]
+		x = ((l << 28) | (l >> 4)) ^ keys[keysi]
 		keysi += 1
 		fval = SP7[x&0x3f]
 		fval |= SP5[(x>>8)&0x3f]
 		fval |= SP3[(x>>16)&0x3f]
 		fval |= SP1[(x>>24)&0x3f]
-		x = l ^ d.keys[keysi]
+		x = l ^ keys[keysi]
 		keysi += 1
 		fval |= SP8[x&0x3f]
 		fval |= SP6[(x>>8)&0x3f]
@@ -419,7 +428,15 @@ func New(password LossBytes) *DES {
 		KnLi += 1
 	}
 
+	// subkeys of decryption are the ones of encryption in reversed rounds
+	decryptKeys := make([]uint32, 32)
+	for i := 0; i < 16; i++ {
+		decryptKeys[i*2] = keys[(15-i)*2]
+		decryptKeys[i*2+1] = keys[(15-i)*2+1]
+	}
+
 	return &DES{
-		keys: keys,
+		keys:        keys,
+		decryptKeys: decryptKeys,
 	}
 }
@@ -0,0 +1,73 @@
+package des
+
+import (
+	"bytes"
+	"errors"
+	"os"
+)
+
+// PasswdKey is the fixed key to obfuscate passwords in `~/.vnc/passwd`,
+// shared by vncpasswd of RealVNC, TightVNC, TigerVNC and so on.
+var PasswdKey = []byte{23, 82, 107, 6, 35, 78, 88, 7}
+
+var ErrInvalidPasswd = errors.New("invalid VNC passwd file")
+
+// EncryptPasswd obfuscates passwords in the format of VNC passwd file,
+// the first one is the full access password, the second one is the optional view-only password.
+// Only the first 8 bytes of each password are used by VNC auth.
+func EncryptPasswd(passwords ...string) ([]byte, error) {
+	d := New(PasswdKey)
+
+	data := make([]byte, 0, len(passwords)*BlockSize)
+	for _, password := range passwords {
+		block := make([]byte, BlockSize)
+		copy(block, password)
+		err := d.Encrypt(block, block)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, block...)
+	}
+
+	return data, nil
+}
+
+// DecryptPasswd reveals passwords from the content of VNC passwd file
+func DecryptPasswd(data []byte) ([]string, error) {
+	if len(data) == 0 || len(data)%BlockSize != 0 {
+		return nil, ErrInvalidPasswd
+	}
+
+	d := New(PasswdKey)
+
+	passwords := make([]string, 0, len(data)/BlockSize)
+	for i := 0; i < len(data); i += BlockSize {
+		block := make([]byte, BlockSize)
+		err := d.Decrypt(block, data[i:i+BlockSize])
+		if err != nil {
+			return nil, err
+		}
+		if j := bytes.IndexByte(block, 0); j != -1 {
+			block = block[:j]
+		}
+		passwords = append(passwords, string(block))
+	}
+
+	return passwords, nil
+}
+
+func WritePasswdFile(filename string, passwords ...string) error {
+	data, err := EncryptPasswd(passwords...)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+func ReadPasswdFile(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptPasswd(data)
+}
@@ -0,0 +1,62 @@
+package des
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path"
+	"testing"
+)
+
+func TestDecrypt(t *testing.T) {
+	d := New([]byte("12345678"))
+
+	raw, _ := hex.DecodeString("da45b933890a179203ae463397ebd75f")
+
+	encrypted := make([]byte, len(raw))
+	err := d.Encrypt(encrypted, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decrypted := make([]byte, len(raw))
+	err = d.Decrypt(decrypted, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(raw, decrypted) {
+		t.Errorf("Decrypt failed, expect %x, got %x", raw, decrypted)
+	}
+}
+
+func TestPasswd(t *testing.T) {
+	// echo password | vncpasswd -f | xxd -p
+	expected, _ := hex.DecodeString("dbd83cfd727a1458")
+
+	data, err := EncryptPasswd("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("EncryptPasswd failed, expect %x, got %x", expected, data)
+	}
+
+	filename := path.Join(t.TempDir(), "passwd")
+	err = WritePasswdFile(filename, "password", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwords, err := ReadPasswdFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passwords) != 2 || passwords[0] != "password" || passwords[1] != "view" {
+		t.Errorf("ReadPasswdFile failed, got %v", passwords)
+	}
+
+	_, err = DecryptPasswd([]byte{1, 2, 3})
+	if err != ErrInvalidPasswd {
+		t.Errorf("expect %v, got %v", ErrInvalidPasswd, err)
+	}
+}
@@ -0,0 +1,44 @@
+package password
+
+import (
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("passwd12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Check(hash); err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(hash, "passwd12") {
+		t.Error("bcrypt hash should match")
+	}
+	if Verify(hash, "passwd13") {
+		t.Error("bcrypt hash should NOT match")
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	// passwd12 with salt `somesalt`, t=2, m=1024, p=1
+	hash := "$argon2id$v=19$m=1024,t=2,p=1$c29tZXNhbHQ$hts2gIrSmEXchto2iO32dOxhw4bCG73xcRIQ+WG8Q44"
+	if err := Check(hash); err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(hash, "passwd12") {
+		t.Error("argon2id hash should match")
+	}
+	if Verify(hash, "passwd13") {
+		t.Error("argon2id hash should NOT match")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if Check("passwd12") != UnsupportedHash {
+		t.Error("plaintext should NOT be accepted as hash")
+	}
+	if Check("$argon2id$v=19$m=1024") != InvalidHash {
+		t.Error("malformed argon2id hash should be rejected")
+	}
+}
@@ -0,0 +1,137 @@
+package selfsigned
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	Organization = "OpenKVM"
+	ValidFor     = 10 * 365 * 24 * time.Hour
+)
+
+var (
+	MissingKeyPair = errors.New("cert and key should be set together")
+)
+
+// Generate creates a self-signed certificate for hosts, which are either IPs or DNS names
+func Generate(hosts ...string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{Organization},
+			CommonName:   Organization,
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(ValidFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// LoadOrGenerate loads the key pair from certFile and keyFile.
+// If both of them are empty, a self-signed certificate is generated in memory,
+// which changes every time the program starts.
+// If the files do NOT exist, a self-signed certificate is generated and saved to them,
+// so that VNC clients can remember it.
+func LoadOrGenerate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		certPEM, keyPEM, err := Generate(hosts()...)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	} else if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, MissingKeyPair
+	}
+
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		certPEM, keyPEM, err := Generate(hosts()...)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		err = os.WriteFile(keyFile, keyPEM, 0600)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		err = os.WriteFile(certFile, certPEM, 0644)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the leaf certificate,
+// in the same format as `openssl x509 -fingerprint -sha256`
+func Fingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(parts, ":")
+}
+
+func hosts() []string {
+	list := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil {
+		list = append(list, hostname)
+	}
+	return list
+}
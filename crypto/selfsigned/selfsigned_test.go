@@ -0,0 +1,56 @@
+package selfsigned
+
+import (
+	"crypto/x509"
+	"path"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	certPEM, keyPEM, err := Generate("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		t.Fatal("empty pem")
+	}
+}
+
+func TestLoadOrGenerate(t *testing.T) {
+	cert, err := LoadOrGenerate("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(cert) == "" {
+		t.Fatal("empty fingerprint")
+	}
+
+	_, err = LoadOrGenerate("cert.pem", "")
+	if err != MissingKeyPair {
+		t.Fatalf("expected %v, got %v", MissingKeyPair, err)
+	}
+
+	dir := t.TempDir()
+	certFile := path.Join(dir, "cert.pem")
+	keyFile := path.Join(dir, "key.pem")
+
+	first, err := LoadOrGenerate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadOrGenerate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Fingerprint(first) != Fingerprint(second) {
+		t.Fatal("saved certificate should be loaded again")
+	}
+
+	leaf, err := x509.ParseCertificate(first.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = leaf.VerifyHostname("localhost"); err != nil {
+		t.Fatal(err)
+	}
+}
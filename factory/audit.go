@@ -0,0 +1,14 @@
+package factory
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/audit"
+)
+
+// AuditFromConfig returns audit.Discard if audit log is disabled
+func AuditFromConfig(conf config.Config) (audit.Logger, error) {
+	if conf.Audit.Path == "" {
+		return audit.Discard, nil
+	}
+	return audit.NewFile(conf.Audit.Path, int64(conf.Audit.MaxSize)*1024*1024, conf.Audit.MaxBackups)
+}
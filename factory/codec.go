@@ -1,11 +1,79 @@
 package factory
 
 import (
+	"fmt"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/kvm/codec"
+	"github.com/allape/openkvm/kvm/codec/hextile"
+	"github.com/allape/openkvm/kvm/codec/raw"
 	"github.com/allape/openkvm/kvm/codec/tight"
+	"github.com/allape/openkvm/kvm/codec/zrle"
+	"slices"
 )
 
-func VideoCodecFromConfig(conf config.Config) (codec.Codec, error) {
-	return &tight.JPEGEncoder{Quality: conf.Video.Quality, SliceCount: conf.Video.SliceCount}, nil
+var DefaultVideoEncodings = []config.VideoEncodingType{
+	config.VideoEncodingZRLE,
+	config.VideoEncodingTight,
+	config.VideoEncodingTightPNG,
+	config.VideoEncodingHextile,
+	config.VideoEncodingRaw,
+}
+
+// VideoCodecsFromConfig returns enabled codecs in the preferred order of the server,
+// Raw is always enabled as the fallback.
+func VideoCodecsFromConfig(conf config.Config) ([]codec.Factory, error) {
+	encodings := conf.Video.Encodings
+	if len(encodings) == 0 {
+		encodings = DefaultVideoEncodings
+	} else if !slices.Contains(encodings, config.VideoEncodingRaw) {
+		encodings = append(slices.Clone(encodings), config.VideoEncodingRaw)
+	}
+
+	factories := make([]codec.Factory, 0, len(encodings))
+
+	for _, encoding := range encodings {
+		switch encoding {
+		case config.VideoEncodingRaw:
+			factories = append(factories, codec.Factory{
+				Encoding: codec.Raw,
+				New: func() codec.Codec {
+					return &raw.Encoder{SliceCount: conf.Video.SliceCount}
+				},
+			})
+		case config.VideoEncodingHextile:
+			factories = append(factories, codec.Factory{
+				Encoding: codec.Hextile,
+				New: func() codec.Codec {
+					return &hextile.Encoder{SliceCount: conf.Video.SliceCount}
+				},
+			})
+		case config.VideoEncodingZRLE:
+			factories = append(factories, codec.Factory{
+				Encoding: codec.ZRLE,
+				New: func() codec.Codec {
+					return &zrle.Encoder{SliceCount: conf.Video.SliceCount}
+				},
+			})
+		case config.VideoEncodingTight:
+			factories = append(factories, codec.Factory{
+				Encoding: codec.Tight,
+				New: func() codec.Codec {
+					return &tight.Encoder{Quality: conf.Video.Quality, SliceCount: conf.Video.SliceCount}
+				},
+			})
+		case config.VideoEncodingTightPNG:
+			factories = append(factories, codec.Factory{
+				Encoding: codec.TightPNG,
+				New: func() codec.Codec {
+					return &tight.PNGEncoder{SliceCount: conf.Video.SliceCount}
+				},
+			})
+		default:
+			return nil, fmt.Errorf("unknown video encoding: %s", encoding)
+		}
+	}
+
+	l.Info().Println("video encodings:", encodings)
+
+	return factories, nil
 }
@@ -0,0 +1,24 @@
+package factory
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/cursor"
+	"image"
+)
+
+// CursorFromConfig returns nil if the cursor is disabled
+func CursorFromConfig(conf config.Config) (*cursor.Cursor, error) {
+	switch conf.Mouse.Cursor {
+	case "", config.CursorArrow:
+		return cursor.Arrow, nil
+	case config.CursorDot:
+		return cursor.Dot, nil
+	case config.CursorNone:
+		return nil, nil
+	default:
+		return cursor.Load(string(conf.Mouse.Cursor), image.Point{
+			X: conf.Mouse.CursorHotspot[0],
+			Y: conf.Mouse.CursorHotspot[1],
+		})
+	}
+}
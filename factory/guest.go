@@ -0,0 +1,33 @@
+package factory
+
+import (
+	"fmt"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/guest"
+	"net/url"
+	"time"
+)
+
+func GuestIssuerFromConfig(conf config.Config) (*guest.Issuer, error) {
+	if conf.VNC.ShareSecret == "" {
+		l.Warn().Println("No share secret set, share links are invalidated on restart")
+	}
+	return guest.New(conf.VNC.ShareSecret, time.Duration(conf.VNC.ShareMaxTTL)*time.Second)
+}
+
+// ShareBaseURLFromConfig returns nil if it is NOT configured
+func ShareBaseURLFromConfig(conf config.Config) (*url.URL, error) {
+	if conf.VNC.ShareBaseURL == "" {
+		return nil, nil
+	}
+
+	base, err := url.Parse(conf.VNC.ShareBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("share base url should be like https://host[:port][/path], got %s", conf.VNC.ShareBaseURL)
+	}
+
+	return base, nil
+}
@@ -0,0 +1,46 @@
+package factory
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/crypto/selfsigned"
+)
+
+// TLSConfigFromConfig returns nil if VeNCrypt is disabled
+func TLSConfigFromConfig(conf config.Config) (*tls.Config, error) {
+	if !conf.VNC.TLS {
+		if conf.VNC.TLSOnly {
+			return nil, errors.New("vnc tls_only requires tls to be enabled")
+		}
+		return nil, nil
+	}
+
+	return tlsConfig("VNC", conf.VNC.TLSCert, conf.VNC.TLSKey)
+}
+
+// WebTLSConfigFromConfig returns nil if HTTPS is disabled
+func WebTLSConfigFromConfig(conf config.Config) (*tls.Config, error) {
+	if !conf.Websocket.TLS {
+		if conf.Websocket.RedirectAddr != "" {
+			return nil, errors.New("websocket redirect_addr requires tls to be enabled")
+		}
+		return nil, nil
+	}
+
+	return tlsConfig("HTTPS", conf.Websocket.TLSCert, conf.Websocket.TLSKey)
+}
+
+func tlsConfig(name, certFile, keyFile string) (*tls.Config, error) {
+	cert, err := selfsigned.LoadOrGenerate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Info().Println(name, "certificate SHA-256 fingerprint:", selfsigned.Fingerprint(cert))
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,260 @@
+package helper
+
+import (
+	"github.com/allape/openkvm/config"
+	"image"
+	"image/draw"
+	"slices"
+)
+
+const (
+	// MinCopyRectLength moves shorter than this are not worth a CopyRect
+	MinCopyRectLength = 8
+	// MaxHashCandidates rows appear more often than this are too common to tell the offset, like blank lines
+	MaxHashCandidates = 4
+)
+
+// CalcCopyRects detects regions moved between two frames, like scrolling in a terminal.
+// The frame is cut into SliceCount bands, each band can have its own offset.
+// Vertical moves are detected in column bands, horizontal moves are only detected if there is no vertical move.
+func CalcCopyRects(previewImage, nextImage image.Image, sliceCount config.SliceCount) []config.CopyRect {
+	if previewImage == nil || nextImage == nil || previewImage == nextImage {
+		return nil
+	}
+
+	bounds := nextImage.Bounds()
+	if previewImage.Bounds() != bounds {
+		return nil
+	}
+
+	sc := max(int(sliceCount), 1)
+
+	prev := pixelReaderOf(previewImage)
+	next := pixelReaderOf(nextImage)
+
+	copies := calcBandMoves(prev, next, bounds, sc, false)
+	if len(copies) == 0 {
+		copies = calcBandMoves(prev, next, bounds, sc, true)
+	}
+
+	return copies
+}
+
+// ApplyCopyRects returns a copy of img with all copies applied in order, just like what VNC client does
+func ApplyCopyRects(img image.Image, copies []config.CopyRect) config.Frame {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, dst.Bounds().Min, draw.Src)
+	for _, c := range copies {
+		draw.Draw(dst, c.Rect, dst, c.Src, draw.Src)
+	}
+	return dst
+}
+
+type pixelReader func(x, y int) uint32
+
+// pixelReaderOf reads pixels without converting color model, values are only comparable between same type of images
+func pixelReaderOf(img image.Image) pixelReader {
+	switch i := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) uint32 {
+			ci := i.COffset(x, y)
+			return uint32(i.Y[i.YOffset(x, y)])<<16 | uint32(i.Cb[ci])<<8 | uint32(i.Cr[ci])
+		}
+	case *image.RGBA:
+		return func(x, y int) uint32 {
+			o := i.PixOffset(x, y)
+			return uint32(i.Pix[o])<<16 | uint32(i.Pix[o+1])<<8 | uint32(i.Pix[o+2])
+		}
+	default:
+		return func(x, y int) uint32 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			return (r>>8)<<16 | (g>>8)<<8 | b>>8
+		}
+	}
+}
+
+// lineHashes hashes each line of band, lines are rows if horizontal is false, otherwise columns
+func lineHashes(read pixelReader, band image.Rectangle, horizontal bool) (hashes []uint64, uniform []bool) {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+
+	outer, inner := band.Min.Y, band.Min.X
+	outerMax, innerMax := band.Max.Y, band.Max.X
+	if horizontal {
+		outer, inner = band.Min.X, band.Min.Y
+		outerMax, innerMax = band.Max.X, band.Max.Y
+	}
+
+	hashes = make([]uint64, 0, outerMax-outer)
+	uniform = make([]bool, 0, outerMax-outer)
+
+	for o := outer; o < outerMax; o++ {
+		hash := uint64(offset64)
+		first := uint32(0)
+		same := true
+		for i := inner; i < innerMax; i++ {
+			var pixel uint32
+			if horizontal {
+				pixel = read(o, i)
+			} else {
+				pixel = read(i, o)
+			}
+			if i == inner {
+				first = pixel
+			} else if pixel != first {
+				same = false
+			}
+			hash ^= uint64(pixel)
+			hash *= prime64
+		}
+		hashes = append(hashes, hash)
+		uniform = append(uniform, same)
+	}
+
+	return hashes, uniform
+}
+
+type move struct {
+	start, length, offset int
+}
+
+// findMoves finds the most voted offset of changed lines, and returns runs of lines moved by this offset.
+// next[i] == prev[i+offset] means line i is moved from line i+offset.
+func findMoves(prev, next []uint64, uniform []bool) []move {
+	index := make(map[uint64][]int)
+	for i, hash := range prev {
+		if len(index[hash]) <= MaxHashCandidates {
+			index[hash] = append(index[hash], i)
+		}
+	}
+
+	votes := make(map[int]int)
+	for i, hash := range next {
+		if hash == prev[i] || uniform[i] {
+			continue
+		}
+		candidates := index[hash]
+		if len(candidates) > MaxHashCandidates {
+			continue
+		}
+		for _, j := range candidates {
+			votes[j-i]++
+		}
+	}
+
+	offset, best := 0, 0
+	for o, v := range votes {
+		if v > best || (v == best && abs(o) < abs(offset)) {
+			offset, best = o, v
+		}
+	}
+	if offset == 0 || best < MinCopyRectLength {
+		return nil
+	}
+
+	var moves []move
+	start := -1
+	for i := 0; i <= len(next); i++ {
+		j := i + offset
+		moved := i < len(next) && j >= 0 && j < len(prev) && next[i] != prev[i] && next[i] == prev[j]
+		if moved && start == -1 {
+			start = i
+		} else if !moved && start != -1 {
+			if i-start >= MinCopyRectLength {
+				moves = append(moves, move{start: start, length: i - start, offset: offset})
+			}
+			start = -1
+		}
+	}
+
+	return moves
+}
+
+func calcBandMoves(prev, next pixelReader, bounds image.Rectangle, sc int, horizontal bool) []config.CopyRect {
+	var copies []config.CopyRect
+
+	for b := 0; b < sc; b++ {
+		var band image.Rectangle
+		if horizontal {
+			size := bounds.Dy() / sc
+			band = image.Rect(bounds.Min.X, bounds.Min.Y+b*size, bounds.Max.X, bounds.Min.Y+(b+1)*size)
+		} else {
+			size := bounds.Dx() / sc
+			band = image.Rect(bounds.Min.X+b*size, bounds.Min.Y, bounds.Min.X+(b+1)*size, bounds.Max.Y)
+		}
+		if b == sc-1 {
+			band.Max = bounds.Max
+		}
+
+		prevHashes, _ := lineHashes(prev, band, horizontal)
+		nextHashes, uniform := lineHashes(next, band, horizontal)
+
+		for _, m := range findMoves(prevHashes, nextHashes, uniform) {
+			c := config.CopyRect{}
+			if horizontal {
+				c.Rect = image.Rect(band.Min.X+m.start, band.Min.Y, band.Min.X+m.start+m.length, band.Max.Y)
+				c.Src = image.Point{X: c.Rect.Min.X + m.offset, Y: c.Rect.Min.Y}
+			} else {
+				c.Rect = image.Rect(band.Min.X, band.Min.Y+m.start, band.Max.X, band.Min.Y+m.start+m.length)
+				c.Src = image.Point{X: c.Rect.Min.X, Y: c.Rect.Min.Y + m.offset}
+			}
+			copies = mergeCopyRect(copies, c, horizontal)
+		}
+	}
+
+	// copies only overlap when they are in the same band, which means they share the same offset.
+	// Moving up (or left) should be done from top to bottom, and moving down from bottom to top,
+	// so that the source is not overwritten before it is copied.
+	slices.SortStableFunc(copies, func(a, b config.CopyRect) int {
+		ao, bo := copyOffset(a, horizontal), copyOffset(b, horizontal)
+		if (ao > 0) != (bo > 0) {
+			return bo - ao
+		}
+		if horizontal {
+			if ao > 0 {
+				return a.Rect.Min.X - b.Rect.Min.X
+			}
+			return b.Rect.Min.X - a.Rect.Min.X
+		}
+		if ao > 0 {
+			return a.Rect.Min.Y - b.Rect.Min.Y
+		}
+		return b.Rect.Min.Y - a.Rect.Min.Y
+	})
+
+	return copies
+}
+
+// mergeCopyRect merges c into the copy of the adjacent band with the same move
+func mergeCopyRect(copies []config.CopyRect, c config.CopyRect, horizontal bool) []config.CopyRect {
+	for i, p := range copies {
+		if p.Src.Sub(p.Rect.Min) != c.Src.Sub(c.Rect.Min) {
+			continue
+		}
+		if horizontal && p.Rect.Min.X == c.Rect.Min.X && p.Rect.Max.X == c.Rect.Max.X && p.Rect.Max.Y == c.Rect.Min.Y {
+			copies[i].Rect.Max.Y = c.Rect.Max.Y
+			return copies
+		}
+		if !horizontal && p.Rect.Min.Y == c.Rect.Min.Y && p.Rect.Max.Y == c.Rect.Max.Y && p.Rect.Max.X == c.Rect.Min.X {
+			copies[i].Rect.Max.X = c.Rect.Max.X
+			return copies
+		}
+	}
+	return append(copies, c)
+}
+
+func copyOffset(c config.CopyRect, horizontal bool) int {
+	if horizontal {
+		return c.Src.X - c.Rect.Min.X
+	}
+	return c.Src.Y - c.Rect.Min.Y
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+package helper
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func stripes(width, height, shift int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// every line is different, like lines of text
+			v := uint8((y + shift) * 7)
+			img.Set(x, y, color.RGBA{R: v, G: uint8(x), B: v ^ uint8(x), A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestCalcCopyRects(t *testing.T) {
+	prev := stripes(64, 64, 0)
+	next := stripes(64, 64, 16) // scrolled up by 16 lines
+
+	copies := CalcCopyRects(prev, next, 4)
+	if len(copies) != 1 {
+		t.Fatalf("expected 1 copy, got %v", copies)
+	}
+
+	c := copies[0]
+	if c.Rect != image.Rect(0, 0, 64, 48) || c.Src != image.Pt(0, 16) {
+		t.Fatalf("unexpected copy: %v", c)
+	}
+
+	applied := ApplyCopyRects(prev, copies)
+	if ImageChanged(applied, next, image.Pt(64, 64), 0, 0, 64, 48) {
+		t.Fatal("copied area should be the same as next frame")
+	}
+	if !ImageChanged(applied, next, image.Pt(64, 64), 0, 48, 64, 16) {
+		t.Fatal("scrolled in area should be different")
+	}
+
+	if copies := CalcCopyRects(prev, prev, 4); len(copies) != 0 {
+		t.Fatalf("expected no copy for same frame, got %v", copies)
+	}
+}
+
+func TestCalcCopyRectsHorizontal(t *testing.T) {
+	prev := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	next := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			prev.Set(x, y, color.RGBA{R: uint8(x * 3), G: uint8(y), A: 0xff})
+			next.Set(x, y, color.RGBA{R: uint8((x + 8) * 3), G: uint8(y), A: 0xff})
+		}
+	}
+
+	copies := CalcCopyRects(prev, next, 4)
+	if len(copies) != 1 {
+		t.Fatalf("expected 1 copy, got %v", copies)
+	}
+	if copies[0].Rect != image.Rect(0, 0, 56, 64) || copies[0].Src != image.Pt(8, 0) {
+		t.Fatalf("unexpected copy: %v", copies[0])
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/allape/openkvm/config"
 	"image"
+	"image/draw"
 	"sync"
+	"sync/atomic"
 )
 
 func ImageChanged(img1, img2 image.Image, size image.Point, offsetX, offsetY, width, height int) bool {
@@ -22,7 +24,8 @@ func ImageChanged(img1, img2 image.Image, size image.Point, offsetX, offsetY, wi
 		for y := offsetY; y < rectMaxHeight; y++ {
 			r1, b1, g1, _ := img1.At(x, y).RGBA()
 			r2, b2, g2, _ := img2.At(x, y).RGBA()
-			if r1 != r2 || b1 != b2 || g1 != g2 {
+			// compare in 8-bit, images in different color models may have different lower bits
+			if r1>>8 != r2>>8 || b1>>8 != b2>>8 || g1>>8 != g2>>8 {
 				return true
 			}
 		}
@@ -84,16 +87,27 @@ func CalcNextImageRects(previewImage, nextImage image.Image, sliceCount config.S
 		}
 	} else {
 		var wait sync.WaitGroup
+		var panicked atomic.Value
 		for i := 0; i < colCount; i++ {
 			for j := 0; j < rowCount; j++ {
 				wait.Add(1)
 				go func(x, y int) {
 					defer wait.Done()
+					// a panic in a worker can NOT be recovered by the caller
+					defer func() {
+						if r := recover(); r != nil {
+							panicked.Store(fmt.Errorf("compare slice %d,%d: %v", x, y, r))
+						}
+					}()
 					rectChangedMarks[x][y] = ImageChanged(previewImage, nextImage, imageSize, x*rectSize.X, y*rectSize.Y, rectSize.X, rectSize.Y)
 				}(i, j)
 			}
 		}
 		wait.Wait()
+
+		if err, ok := panicked.Load().(error); ok {
+			return nil, err
+		}
 	}
 
 	rects := make([]config.Rect, 0)
@@ -112,3 +126,58 @@ func CalcNextImageRects(previewImage, nextImage image.Image, sliceCount config.S
 
 	return rects, nil
 }
+
+// AlignRectToSlices expands rect to the boundaries of slices which it touches
+func AlignRectToSlices(rect image.Rectangle, bounds image.Rectangle, sliceCount config.SliceCount) image.Rectangle {
+	sc := max(int(sliceCount), 1)
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return image.Rectangle{}
+	}
+
+	size := image.Point{X: max(bounds.Dx()/sc, 1), Y: max(bounds.Dy()/sc, 1)}
+
+	rect = rect.Sub(bounds.Min)
+	aligned := image.Rect(
+		rect.Min.X/size.X*size.X,
+		rect.Min.Y/size.Y*size.Y,
+		(rect.Max.X+size.X-1)/size.X*size.X,
+		(rect.Max.Y+size.Y-1)/size.Y*size.Y,
+	)
+
+	return aligned.Add(bounds.Min).Intersect(bounds)
+}
+
+// CropFrame returns a frame of size, which takes the top-left part of frame, the rest is black
+func CropFrame(frame image.Image, size image.Point) config.Frame {
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(dst, dst.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	return dst
+}
+
+// InvertRect returns a copy of img with colors in rect inverted,
+// every pixel in rect differs from img, so that the slices touched by rect are treated as changed
+func InvertRect(img image.Image, rect image.Rectangle) config.Frame {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, dst.Bounds().Min, draw.Src)
+
+	rect = rect.Intersect(dst.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] ^= 0xff
+			dst.Pix[o+1] ^= 0xff
+			dst.Pix[o+2] ^= 0xff
+		}
+	}
+
+	return dst
+}
+
+// MergeFrames returns a new frame which takes pixels in rect from inside, and the others from outside
+func MergeFrames(outside, inside image.Image, rect image.Rectangle) config.Frame {
+	dst := image.NewRGBA(outside.Bounds())
+	draw.Draw(dst, dst.Bounds(), outside, dst.Bounds().Min, draw.Src)
+	draw.Draw(dst, rect, inside, rect.Min, draw.Src)
+	return dst
+}
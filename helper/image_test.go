@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAlignRectToSlices(t *testing.T) {
+	bounds := image.Rect(0, 0, 1280, 720)
+
+	cases := []struct {
+		rect     image.Rectangle
+		expected image.Rectangle
+	}{
+		{image.Rect(0, 0, 1280, 720), bounds},
+		{image.Rect(0, 0, 1, 1), image.Rect(0, 0, 320, 180)},
+		{image.Rect(300, 170, 330, 190), image.Rect(0, 0, 640, 360)},
+		{image.Rect(1000, 700, 2000, 2000), image.Rect(960, 540, 1280, 720)},
+		{image.Rect(0, 0, 0, 0), image.Rectangle{}},
+	}
+
+	for _, c := range cases {
+		if aligned := AlignRectToSlices(c.rect, bounds, 4); aligned != c.expected {
+			t.Fatalf("%v: expected %v, got %v", c.rect, c.expected, aligned)
+		}
+	}
+}
+
+func TestMergeFrames(t *testing.T) {
+	outside := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	inside := image.NewUniform(color.RGBA{R: 0xff, A: 0xff})
+
+	merged := MergeFrames(outside, inside, image.Rect(0, 0, 2, 2))
+	if r, _, _, _ := merged.At(1, 1).RGBA(); r != 0xffff {
+		t.Fatalf("expected pixel from inside, got %v", merged.At(1, 1))
+	}
+	if r, _, _, _ := merged.At(2, 2).RGBA(); r != 0 {
+		t.Fatalf("expected pixel from outside, got %v", merged.At(2, 2))
+	}
+}
+
+func TestCropFrame(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 8, 2))
+	frame.Set(1, 1, color.RGBA{R: 0xff, A: 0xff})
+
+	cropped := CropFrame(frame, image.Pt(4, 4))
+	if cropped.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Fatalf("expected bounds %v, got %v", image.Rect(0, 0, 4, 4), cropped.Bounds())
+	}
+	if r, _, _, _ := cropped.At(1, 1).RGBA(); r != 0xffff {
+		t.Fatalf("expected pixel from frame, got %v", cropped.At(1, 1))
+	}
+	if r, g, b, _ := cropped.At(3, 3).RGBA(); r|g|b != 0 {
+		t.Fatalf("expected black outside frame, got %v", cropped.At(3, 3))
+	}
+}
+
+func TestInvertRect(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(0, 0, color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff})
+	img.Set(3, 3, color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff})
+
+	inverted := InvertRect(img, image.Rect(0, 0, 2, 2))
+	if r, g, b, _ := inverted.At(0, 0).RGBA(); r>>8 != 0xed || g>>8 != 0xcb || b>>8 != 0xa9 {
+		t.Fatalf("expected inverted pixel, got %v", inverted.At(0, 0))
+	}
+	if r, _, _, _ := inverted.At(3, 3).RGBA(); r>>8 != 0x12 {
+		t.Fatalf("expected pixel outside rect kept, got %v", inverted.At(3, 3))
+	}
+	if !ImageChanged(img, inverted, img.Bounds().Size(), 0, 0, 2, 2) || ImageChanged(img, inverted, img.Bounds().Size(), 2, 2, 2, 2) {
+		t.Fatal("only pixels in rect should be changed")
+	}
+}
@@ -0,0 +1,219 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/allape/gogger"
+	"os"
+	"sync"
+	"time"
+)
+
+var l = gogger.New("kvm.audit")
+
+type EventType string
+
+const (
+	Connect    EventType = "connect"
+	Disconnect EventType = "disconnect"
+	AuthFail   EventType = "auth_fail"
+	Lockout    EventType = "lockout"
+	Kick       EventType = "kick"
+	Button     EventType = "button"
+	Clipboard  EventType = "clipboard"
+	ConfigLoad EventType = "config_load"
+	Share      EventType = "share"
+	Unshare    EventType = "unshare"
+)
+
+type Via string
+
+const (
+	RFB  Via = "rfb"
+	HTTP Via = "http"
+)
+
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       EventType `json:"type"`
+	Via        Via       `json:"via,omitempty"`
+	ClientID   uint64    `json:"client_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+
+	// Target is the client ID kicked out
+	Target uint64 `json:"target,omitempty"`
+	// Button power, reset or extra
+	Button string `json:"button,omitempty"`
+	// Duration of session or button press, in milliseconds
+	Duration int64 `json:"duration_ms,omitempty"`
+	// BytesSent of session
+	BytesSent uint64 `json:"bytes_sent,omitempty"`
+	// Length of clipboard text
+	Length int `json:"length,omitempty"`
+	// Text of clipboard, only if it is enabled
+	Text string `json:"text,omitempty"`
+	// Guest ID of share link
+	Guest string `json:"guest,omitempty"`
+	// Control share link allows input, otherwise view-only
+	Control bool `json:"control,omitempty"`
+	// Path of config file
+	Path string `json:"path,omitempty"`
+}
+
+type Logger interface {
+	Log(event Event)
+	Close() error
+}
+
+type discard struct{}
+
+func (discard) Log(Event) {}
+
+func (discard) Close() error {
+	return nil
+}
+
+// Discard drops all events
+var Discard Logger = discard{}
+
+// File appends events as JSON lines to a file,
+// it is rotated to `name.1`, `name.2`, ... when it grows bigger than MaxSize.
+type File struct {
+	Name string
+	// MaxSize in bytes, 0 means no rotation
+	MaxSize int64
+	// MaxBackups count of rotated files to keep, 0 means keeping all of them
+	MaxBackups int
+
+	locker sync.Locker
+	file   *os.File
+	size   int64
+}
+
+func (f *File) Log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		l.Error().Println("marshal event:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.MaxSize {
+		err = f.rotate()
+		if err != nil {
+			l.Error().Println("rotate:", err)
+		}
+	}
+
+	if f.file == nil {
+		err = f.open()
+		if err != nil {
+			l.Error().Println("open:", err)
+			return
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		l.Error().Println("write:", err)
+	}
+}
+
+func (f *File) Close() error {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open f.locker should be held by caller
+func (f *File) open() error {
+	file, err := os.OpenFile(f.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = stat.Size()
+
+	return nil
+}
+
+// rotate f.locker should be held by caller
+func (f *File) rotate() error {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+
+	n := 1
+	for {
+		_, err := os.Stat(backupName(f.Name, n))
+		if err != nil {
+			break
+		}
+		n++
+	}
+
+	// drop the oldest ones, then shift name.n-1 to name.n, ..., name to name.1
+	for ; n > 1; n-- {
+		if f.MaxBackups > 0 && n > f.MaxBackups {
+			_ = os.Remove(backupName(f.Name, n-1))
+			continue
+		}
+		err := os.Rename(backupName(f.Name, n-1), backupName(f.Name, n))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := os.Rename(f.Name, backupName(f.Name, 1))
+	if err != nil {
+		return err
+	}
+
+	f.size = 0
+
+	return nil
+}
+
+func backupName(name string, n int) string {
+	return fmt.Sprintf("%s.%d", name, n)
+}
+
+func NewFile(name string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{
+		Name:       name,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		locker:     &sync.Mutex{},
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+)
+
+func readEvents(t *testing.T, name string) []Event {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestFile(t *testing.T) {
+	name := path.Join(t.TempDir(), "audit.log")
+
+	f, err := NewFile(name, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Log(Event{Type: Connect, ClientID: 1, Username: "openkvm"})
+	f.Log(Event{Type: Clipboard, ClientID: 1, Length: 5})
+	err = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// append to the existing file
+	f, err = NewFile(name, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Log(Event{Type: Disconnect, ClientID: 1})
+	_ = f.Close()
+
+	events := readEvents(t, name)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].Type != Connect || events[0].Username != "openkvm" || events[0].Time.IsZero() {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events[1].Length != 5 || events[2].Type != Disconnect {
+		t.Errorf("unexpected events %+v", events[1:])
+	}
+}
+
+func TestFileRotate(t *testing.T) {
+	name := path.Join(t.TempDir(), "audit.log")
+
+	f, err := NewFile(name, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// every event is bigger than MaxSize, so each one is in its own file
+	for i := uint64(1); i <= 4; i++ {
+		f.Log(Event{Type: Connect, ClientID: i})
+	}
+
+	for n, expected := range map[string]uint64{
+		name:        4,
+		name + ".1": 3,
+		name + ".2": 2,
+	} {
+		events := readEvents(t, n)
+		if len(events) != 1 || events[0].ClientID != expected {
+			t.Errorf("%s: expected client %d, got %+v", n, expected, events)
+		}
+	}
+
+	if _, err = os.Stat(name + ".3"); err == nil {
+		t.Error("backups more than MaxBackups should be removed")
+	}
+}
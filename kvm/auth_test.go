@@ -0,0 +1,44 @@
+package kvm
+
+import (
+	"encoding/binary"
+	"github.com/allape/openkvm/config"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuthPlainTooLong(t *testing.T) {
+	s, err := New(nil, nil, nil, nil, nil, Options{Config: config.Config{VNC: config.VNC{
+		Users: []config.User{{Username: "openkvm", Password: "secret"}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, conn := net.Pipe()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	client := NewClient(server, time.Second)
+	client.RemoteAddr = "192.168.1.2"
+	client.respSecurityType = Plain
+
+	go func() {
+		// lengths overflow uint32 when added
+		lengths := binary.BigEndian.AppendUint32(nil, 0xffffffff)
+		lengths = binary.BigEndian.AppendUint32(lengths, 2)
+		_, _ = conn.Write(lengths)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	ok, _ := s.auth(client)
+	if ok {
+		t.Fatal("auth should fail")
+	}
+	if s.Lockout.Blocked(client.RemoteAddr) == 0 {
+		t.Fatal("too long credentials should be counted as a failure")
+	}
+}
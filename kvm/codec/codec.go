@@ -1,9 +1,285 @@
 package codec
 
 import (
+	"fmt"
 	"github.com/allape/openkvm/config"
+	"image"
 )
 
+// Encoding
+// https://datatracker.ietf.org/doc/html/rfc6143#section-7.7
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#encodings
+type Encoding int32
+
+const (
+	Raw      Encoding = 0
+	CopyRect Encoding = 1
+	RRE      Encoding = 2
+	Hextile  Encoding = 5
+	Tight    Encoding = 7
+	ZRLE     Encoding = 16
+	TightPNG Encoding = -260
+
+	// pseudo-encodings
+
+	DesktopSize         Encoding = -223
+	LastRect            Encoding = -224
+	Cursor              Encoding = -239
+	XCursor             Encoding = -240
+	ExtendedDesktopSize Encoding = -308
+	Fence               Encoding = -312
+	ContinuousUpdates   Encoding = -313
+
+	JPEGQualityLevel0 Encoding = -32
+	JPEGQualityLevel9 Encoding = -23
+	CompressionLevel0 Encoding = -256
+	CompressionLevel9 Encoding = -247
+)
+
+var encodingNames = map[Encoding]string{
+	Raw:                 "Raw",
+	CopyRect:            "CopyRect",
+	RRE:                 "RRE",
+	Hextile:             "Hextile",
+	Tight:               "Tight",
+	ZRLE:                "ZRLE",
+	TightPNG:            "TightPNG",
+	DesktopSize:         "DesktopSize",
+	LastRect:            "LastRect",
+	Cursor:              "Cursor",
+	XCursor:             "XCursor",
+	ExtendedDesktopSize: "ExtendedDesktopSize",
+	Fence:               "Fence",
+	ContinuousUpdates:   "ContinuousUpdates",
+}
+
+func (e Encoding) String() string {
+	if name, ok := encodingNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Encoding(%d)", int32(e))
+}
+
 type Codec interface {
-	FramebufferUpdate(previewFrame, nextFrame config.Frame) ([]byte, error)
+	Encoding() Encoding
+	FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat PixelFormat) ([]byte, error)
+}
+
+// PseudoEncodingsSetter is implemented by codecs which are tuned by pseudo-encodings,
+// like JPEG quality level and compression level.
+type PseudoEncodingsSetter interface {
+	SetPseudoEncodings(encodings []Encoding)
+}
+
+// Factory creates a Codec for each client,
+// because codecs may hold states which can NOT be shared between clients, like zlib streams.
+type Factory struct {
+	Encoding Encoding
+	New      func() Codec
+}
+
+// AppendFramebufferUpdateHeader
+//
+//	+--------------+--------------+----------------------+
+//	| No. of bytes | Type [Value] | Description          |
+//	+--------------+--------------+----------------------+
+//	| 1            | U8 [0]       | message-type         |
+//	| 1            |              | padding              |
+//	| 2            | U16          | number-of-rectangles |
+//	+--------------+--------------+----------------------+
+func AppendFramebufferUpdateHeader(dst []byte, count int) []byte {
+	return append(dst,
+		0,                           // FramebufferUpdate
+		0,                           // padding
+		byte(count>>8), byte(count), // Number of rectangles
+	)
+}
+
+// AppendRectHeader
+//
+//	+--------------+--------------+---------------+
+//	| No. of bytes | Type [Value] | Description   |
+//	+--------------+--------------+---------------+
+//	| 2            | U16          | x-position    |
+//	| 2            | U16          | y-position    |
+//	| 2            | U16          | width         |
+//	| 2            | U16          | height        |
+//	| 4            | S32          | encoding-type |
+//	+--------------+--------------+---------------+
+func AppendRectHeader(dst []byte, x, y, width, height int, encoding Encoding) []byte {
+	return append(dst,
+		byte(x>>8), byte(x), // x-position
+		byte(y>>8), byte(y), // y-position
+		byte(width>>8), byte(width), // width
+		byte(height>>8), byte(height), // height
+		byte(encoding>>24), byte(encoding>>16), byte(encoding>>8), byte(encoding), // encoding-type
+	)
+}
+
+// AppendCopyRect appends a rect in CopyRect encoding
+// https://datatracker.ietf.org/doc/html/rfc6143#section-7.7.2
+//
+//	+--------------+--------------+----------------+
+//	| No. of bytes | Type [Value] | Description    |
+//	+--------------+--------------+----------------+
+//	| 2            | U16          | src-x-position |
+//	| 2            | U16          | src-y-position |
+//	+--------------+--------------+----------------+
+func AppendCopyRect(dst []byte, c config.CopyRect) []byte {
+	dst = AppendRectHeader(dst, c.Rect.Min.X, c.Rect.Min.Y, c.Rect.Dx(), c.Rect.Dy(), CopyRect)
+	return append(dst,
+		byte(c.Src.X>>8), byte(c.Src.X), // src-x-position
+		byte(c.Src.Y>>8), byte(c.Src.Y), // src-y-position
+	)
+}
+
+// PrependRects inserts count encoded rects in front of the rects of a FramebufferUpdate message
+func PrependRects(update []byte, rects []byte, count int) []byte {
+	if count == 0 {
+		return update
+	}
+	if len(update) < 4 {
+		return append(AppendFramebufferUpdateHeader(nil, count), rects...)
+	}
+
+	count += int(update[2])<<8 | int(update[3])
+
+	buffer := make([]byte, 0, len(update)+len(rects))
+	buffer = AppendFramebufferUpdateHeader(buffer, count)
+	buffer = append(buffer, rects...)
+	return append(buffer, update[4:]...)
+}
+
+// AppendRects appends count encoded rects to the end of the rects of a FramebufferUpdate message
+func AppendRects(update []byte, rects []byte, count int) []byte {
+	if count == 0 {
+		return update
+	}
+	if len(update) < 4 {
+		return append(AppendFramebufferUpdateHeader(nil, count), rects...)
+	}
+
+	count += int(update[2])<<8 | int(update[3])
+
+	buffer := make([]byte, 0, len(update)+len(rects))
+	buffer = AppendFramebufferUpdateHeader(buffer, count)
+	buffer = append(buffer, update[4:]...)
+	return append(buffer, rects...)
+}
+
+// AppendDesktopSize appends a DesktopSize pseudo-rect, which has no data
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#desktopsize-pseudo-encoding
+func AppendDesktopSize(dst []byte, width, height int) []byte {
+	return AppendRectHeader(dst, 0, 0, width, height, DesktopSize)
+}
+
+// ExtendedDesktopSize reasons, in x-position of the rect
+const (
+	DesktopSizeChangedByServer = 0
+	DesktopSizeChangedByClient = 1
+	DesktopSizeChangedByOther  = 2
+)
+
+// ExtendedDesktopSize statuses, in y-position of the rect
+const (
+	DesktopSizeNoError           = 0
+	DesktopSizeProhibited        = 1
+	DesktopSizeOutOfResources    = 2
+	DesktopSizeInvalidScreenData = 3
+)
+
+// AppendExtendedDesktopSize appends an ExtendedDesktopSize pseudo-rect with a single screen
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendeddesktopsize-pseudo-encoding
+//
+//	+--------------+--------------+-------------------+
+//	| No. of bytes | Type [Value] | Description       |
+//	+--------------+--------------+-------------------+
+//	| 1            | U8           | number-of-screens |
+//	| 3            |              | padding           |
+//	| 4            | U32          | id                |
+//	| 2            | U16          | x-position        |
+//	| 2            | U16          | y-position        |
+//	| 2            | U16          | width             |
+//	| 2            | U16          | height            |
+//	| 4            | U32          | flags             |
+//	+--------------+--------------+-------------------+
+func AppendExtendedDesktopSize(dst []byte, reason, status, width, height int) []byte {
+	dst = AppendRectHeader(dst, reason, status, width, height, ExtendedDesktopSize)
+	return append(dst,
+		1,       // number-of-screens
+		0, 0, 0, // padding
+		0, 0, 0, 0, // id
+		0, 0, // x-position
+		0, 0, // y-position
+		byte(width>>8), byte(width), // width
+		byte(height>>8), byte(height), // height
+		0, 0, 0, 0, // flags
+	)
+}
+
+// appendCursorMask appends a bitmask of img, a bit is set when fn returns true for the pixel
+func appendCursorMask(dst []byte, img image.Image, fn func(r, g, b, a uint32) bool) []byte {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := make([]byte, (bounds.Dx()+7)/8)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if fn(img.At(x, y).RGBA()) {
+				i := x - bounds.Min.X
+				row[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		dst = append(dst, row...)
+	}
+	return dst
+}
+
+func isCursorPixelVisible(_, _, _, a uint32) bool {
+	return a >= 0x8000
+}
+
+// AppendCursor appends a Cursor pseudo-rect, the position of rect is the hotspot
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-pseudo-encoding
+//
+//	+--------------------------------+--------------+---------------+
+//	| No. of bytes                   | Type [Value] | Description   |
+//	+--------------------------------+--------------+---------------+
+//	| width * height * bytesPerPixel | PIXEL array  | cursor-pixels |
+//	| div(width + 7, 8) * height     | U8 array     | bitmask       |
+//	+--------------------------------+--------------+---------------+
+func AppendCursor(dst []byte, img image.Image, hotspot image.Point, pixelFormat PixelFormat) []byte {
+	bounds := img.Bounds()
+	dst = AppendRectHeader(dst, hotspot.X, hotspot.Y, bounds.Dx(), bounds.Dy(), Cursor)
+	dst = pixelFormat.AppendPixels(dst, img)
+	return appendCursorMask(dst, img, isCursorPixelVisible)
+}
+
+// AppendXCursor appends a XCursor pseudo-rect in black and white, dark pixels are drawn in black
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#x-cursor-pseudo-encoding
+//
+//	+----------------------------+--------------+-----------------+
+//	| No. of bytes               | Type [Value] | Description     |
+//	+----------------------------+--------------+-----------------+
+//	| 1                          | U8           | primary-r       |
+//	| 1                          | U8           | primary-g       |
+//	| 1                          | U8           | primary-b       |
+//	| 1                          | U8           | secondary-r     |
+//	| 1                          | U8           | secondary-g     |
+//	| 1                          | U8           | secondary-b     |
+//	| div(width + 7, 8) * height | U8 array     | bitmap          |
+//	| div(width + 7, 8) * height | U8 array     | bitmask         |
+//	+----------------------------+--------------+-----------------+
+func AppendXCursor(dst []byte, img image.Image, hotspot image.Point) []byte {
+	bounds := img.Bounds()
+	dst = AppendRectHeader(dst, hotspot.X, hotspot.Y, bounds.Dx(), bounds.Dy(), XCursor)
+	if bounds.Empty() {
+		return dst
+	}
+	dst = append(dst,
+		0x00, 0x00, 0x00, // primary
+		0xff, 0xff, 0xff, // secondary
+	)
+	dst = appendCursorMask(dst, img, func(r, g, b, a uint32) bool {
+		return isCursorPixelVisible(r, g, b, a) && r*299+g*587+b*114 < 0x8000*1000
+	})
+	return appendCursorMask(dst, img, isCursorPixelVisible)
 }
@@ -0,0 +1,82 @@
+package codec
+
+import (
+	"bytes"
+	"github.com/allape/openkvm/config"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPrependRects(t *testing.T) {
+	update := append(AppendFramebufferUpdateHeader(nil, 1), 0xaa)
+	copyRect := AppendCopyRect(nil, config.CopyRect{Rect: image.Rect(0, 0, 4, 2), Src: image.Pt(0, 2)})
+
+	merged := PrependRects(update, copyRect, 1)
+
+	expected := append(AppendFramebufferUpdateHeader(nil, 2), copyRect...)
+	expected = append(expected, 0xaa)
+	if !bytes.Equal(merged, expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+
+	if !bytes.Equal(PrependRects(update, nil, 0), update) {
+		t.Fatal("update should not be changed without rects")
+	}
+}
+
+func TestAppendRects(t *testing.T) {
+	update := append(AppendFramebufferUpdateHeader(nil, 1), 0xaa)
+	desktopSize := AppendDesktopSize(nil, 1920, 1080)
+
+	merged := AppendRects(update, desktopSize, 1)
+
+	expected := append(AppendFramebufferUpdateHeader(nil, 2), 0xaa)
+	expected = append(expected, desktopSize...)
+	if !bytes.Equal(merged, expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+
+	if !bytes.Equal(AppendRects(nil, desktopSize, 1), append(AppendFramebufferUpdateHeader(nil, 1), desktopSize...)) {
+		t.Fatal("header should be created for empty update")
+	}
+}
+
+func TestAppendExtendedDesktopSize(t *testing.T) {
+	rect := AppendExtendedDesktopSize(nil, DesktopSizeChangedByServer, DesktopSizeNoError, 1920, 1080)
+	if len(rect) != 12+4+16 {
+		t.Fatalf("unexpected length: %d", len(rect))
+	}
+	if !bytes.Equal(rect[:12], AppendRectHeader(nil, 0, 0, 1920, 1080, ExtendedDesktopSize)) {
+		t.Fatalf("unexpected header: %v", rect[:12])
+	}
+	if rect[12] != 1 || rect[24] != 1920>>8 || rect[27] != 1080&0xff {
+		t.Fatalf("unexpected screen: %v", rect[12:])
+	}
+}
+
+func TestAppendCursor(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 9, 1))
+	img.Set(0, 0, color.RGBA{A: 0xff})
+	img.Set(8, 0, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	rect := AppendCursor(nil, img, image.Pt(1, 0), DefaultPixelFormat)
+	if !bytes.Equal(rect[:12], AppendRectHeader(nil, 1, 0, 9, 1, Cursor)) {
+		t.Fatalf("unexpected header: %v", rect[:12])
+	}
+	if len(rect) != 12+9*4+2 {
+		t.Fatalf("unexpected length: %d", len(rect))
+	}
+	if mask := rect[len(rect)-2:]; !bytes.Equal(mask, []byte{0x80, 0x80}) {
+		t.Fatalf("unexpected mask: %08b", mask)
+	}
+
+	rect = AppendXCursor(nil, img, image.Pt(1, 0))
+	if len(rect) != 12+6+2+2 {
+		t.Fatalf("unexpected length: %d", len(rect))
+	}
+	// only the black pixel is primary
+	if bitmap := rect[18:20]; !bytes.Equal(bitmap, []byte{0x80, 0x00}) {
+		t.Fatalf("unexpected bitmap: %08b", bitmap)
+	}
+}
@@ -0,0 +1,218 @@
+package hextile
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/helper"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+)
+
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#hextile-encoding
+
+const (
+	TileSize = 16
+
+	MaxSubrects = 255
+)
+
+// sub-encoding mask
+const (
+	Raw                 byte = 1
+	BackgroundSpecified byte = 2
+	ForegroundSpecified byte = 4
+	AnySubrects         byte = 8
+	SubrectsColoured    byte = 16
+)
+
+type Encoder struct {
+	codec.Codec
+
+	SliceCount config.SliceCount
+}
+
+func (e *Encoder) Encoding() codec.Encoding {
+	return codec.Hextile
+}
+
+func (e *Encoder) FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat codec.PixelFormat) ([]byte, error) {
+	rects, err := helper.CalcNextImageRects(previewFrame, nextFrame, e.SliceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := codec.AppendFramebufferUpdateHeader(nil, len(rects))
+
+	for _, rect := range rects {
+		size := rect.Frame.Bounds().Size()
+		payload = codec.AppendRectHeader(payload, int(rect.X), int(rect.Y), size.X, size.Y, codec.Hextile)
+		payload = appendTiles(payload, rect.Frame, pixelFormat)
+	}
+
+	return payload, nil
+}
+
+// tileState is the background and foreground which are carried over from the previous tile in the same rect
+type tileState struct {
+	pf codec.PixelFormat
+
+	background      uint32
+	backgroundValid bool
+	foreground      uint32
+	foregroundValid bool
+}
+
+func appendTiles(dst []byte, img image.Image, pf codec.PixelFormat) []byte {
+	bounds := img.Bounds()
+	state := &tileState{pf: pf}
+	pixels := make([]uint32, 0, TileSize*TileSize)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += TileSize {
+		for x := bounds.Min.X; x < bounds.Max.X; x += TileSize {
+			tile := image.Rect(x, y, x+TileSize, y+TileSize).Intersect(bounds)
+
+			pixels = pixels[:0]
+			for ty := tile.Min.Y; ty < tile.Max.Y; ty++ {
+				for tx := tile.Min.X; tx < tile.Max.X; tx++ {
+					pixels = append(pixels, pf.Pixel(img.At(tx, ty)))
+				}
+			}
+
+			dst = state.appendTile(dst, pixels, tile.Dx())
+		}
+	}
+
+	return dst
+}
+
+type subrect struct {
+	pixel               uint32
+	x, y, width, height int
+}
+
+func (s *tileState) appendTile(dst []byte, pixels []uint32, width int) []byte {
+	height := len(pixels) / width
+	bpp := s.pf.BytesPerPixel()
+
+	counts := make(map[uint32]int)
+	background := pixels[0]
+	for _, pixel := range pixels {
+		counts[pixel]++
+		if counts[pixel] > counts[background] {
+			background = pixel
+		}
+	}
+
+	var mask byte
+	var body []byte
+
+	if !s.backgroundValid || s.background != background {
+		mask |= BackgroundSpecified
+		body = s.pf.AppendPixelValue(body, background)
+	}
+
+	if len(counts) == 1 {
+		s.background, s.backgroundValid = background, true
+		return append(append(dst, mask), body...)
+	}
+
+	subrects := findSubrects(pixels, width, height, background)
+	if len(subrects) > MaxSubrects {
+		return s.appendRawTile(dst, pixels)
+	}
+
+	mask |= AnySubrects
+
+	mono := len(counts) == 2
+	if mono {
+		foreground := subrects[0].pixel
+		if !s.foregroundValid || s.foreground != foreground {
+			mask |= ForegroundSpecified
+			body = s.pf.AppendPixelValue(body, foreground)
+		}
+	} else {
+		mask |= SubrectsColoured
+	}
+
+	body = append(body, byte(len(subrects)))
+	for _, r := range subrects {
+		if !mono {
+			body = s.pf.AppendPixelValue(body, r.pixel)
+		}
+		body = append(body,
+			byte(r.x<<4|r.y),                  // x-and-y-position
+			byte((r.width-1)<<4|(r.height-1)), // width-and-height
+		)
+	}
+
+	if len(body) >= len(pixels)*bpp {
+		return s.appendRawTile(dst, pixels)
+	}
+
+	s.background, s.backgroundValid = background, true
+	if mono {
+		s.foreground, s.foregroundValid = subrects[0].pixel, true
+	} else {
+		s.foregroundValid = false
+	}
+
+	return append(append(dst, mask), body...)
+}
+
+// appendRawTile appends the tile as raw pixels, background and foreground become undefined after a raw tile.
+func (s *tileState) appendRawTile(dst []byte, pixels []uint32) []byte {
+	s.backgroundValid = false
+	s.foregroundValid = false
+
+	dst = append(dst, Raw)
+	for _, pixel := range pixels {
+		dst = s.pf.AppendPixelValue(dst, pixel)
+	}
+	return dst
+}
+
+// findSubrects covers all pixels which are not background with rectangles of the same color,
+// each rectangle grows to the right first, then grows downward as long as the rows are the same.
+func findSubrects(pixels []uint32, width, height int, background uint32) []subrect {
+	covered := make([]bool, len(pixels))
+	var subrects []subrect
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			pixel := pixels[i]
+			if pixel == background || covered[i] {
+				continue
+			}
+
+			w := 1
+			for x+w < width && pixels[i+w] == pixel && !covered[i+w] {
+				w++
+			}
+
+			h := 1
+		grow:
+			for y+h < height {
+				for dx := 0; dx < w; dx++ {
+					j := (y+h)*width + x + dx
+					if pixels[j] != pixel || covered[j] {
+						break grow
+					}
+				}
+				h++
+			}
+
+			for dy := 0; dy < h; dy++ {
+				for dx := 0; dx < w; dx++ {
+					covered[(y+dy)*width+x+dx] = true
+				}
+			}
+
+			subrects = append(subrects, subrect{pixel: pixel, x: x, y: y, width: w, height: h})
+			if len(subrects) > MaxSubrects {
+				return subrects
+			}
+		}
+	}
+
+	return subrects
+}
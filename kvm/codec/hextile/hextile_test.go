@@ -0,0 +1,54 @@
+package hextile
+
+import (
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestAppendTiles(t *testing.T) {
+	pf := codec.PixelFormat{BitsPerPixel: 8, Depth: 8}
+
+	// 32x16 black, with a 2x3 white box at (1, 2) in the second tile
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.Black)
+		}
+	}
+	for y := 2; y < 5; y++ {
+		for x := 17; x < 19; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+
+	bs := appendTiles(nil, img, pf)
+	expected := []byte{
+		BackgroundSpecified, 0x00, // solid black
+		AnySubrects | ForegroundSpecified, 0xff, 1, 0x12, 0x12, // background is carried over
+	}
+	if !slices.Equal(bs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, bs)
+	}
+}
+
+func TestAppendRawTile(t *testing.T) {
+	pf := codec.PixelFormat{BitsPerPixel: 8, Depth: 8}
+
+	// every pixel is different from its neighbour
+	pixels := make([]uint32, 4*4)
+	for i := range pixels {
+		pixels[i] = uint32(i % 3)
+	}
+
+	state := &tileState{pf: pf}
+	bs := state.appendTile(nil, pixels, 4)
+	if bs[0] != Raw || len(bs) != 1+len(pixels) {
+		t.Fatalf("Expected raw tile, got %v", bs)
+	}
+	if state.backgroundValid || state.foregroundValid {
+		t.Fatal("Expected background and foreground to be invalid after raw tile")
+	}
+}
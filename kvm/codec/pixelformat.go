@@ -0,0 +1,197 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// PixelFormatSize is the size of PIXEL_FORMAT in bytes, padding included
+const PixelFormatSize = 16
+
+var (
+	InvalidPixelFormat = errors.New("invalid pixel format")
+)
+
+// PixelFormat
+// https://datatracker.ietf.org/doc/html/rfc6143#section-7.4
+//
+//	+--------------+--------------+-----------------+
+//	| No. of bytes | Type [Value] | Description     |
+//	+--------------+--------------+-----------------+
+//	| 1            | U8           | bits-per-pixel  |
+//	| 1            | U8           | depth           |
+//	| 1            | U8           | big-endian-flag |
+//	| 1            | U8           | true-color-flag |
+//	| 2            | U16          | red-max         |
+//	| 2            | U16          | green-max       |
+//	| 2            | U16          | blue-max        |
+//	| 1            | U8           | red-shift       |
+//	| 1            | U8           | green-shift     |
+//	| 1            | U8           | blue-shift      |
+//	| 3            |              | padding         |
+//	+--------------+--------------+-----------------+
+type PixelFormat struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    uint8
+	TrueColor    uint8
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+}
+
+// DefaultPixelFormat is the native format of the server, 32bpp true color in little endian
+var DefaultPixelFormat = PixelFormat{
+	BitsPerPixel: 32,
+	Depth:        24,
+	BigEndian:    0,
+	TrueColor:    1,
+	RedMax:       0xff,
+	GreenMax:     0xff,
+	BlueMax:      0xff,
+	RedShift:     16,
+	GreenShift:   8,
+	BlueShift:    0,
+}
+
+// ColourMap is the palette used for clients which are not in true color mode.
+// It is a 3-3-2 RGB palette, the index of a color is RRRGGGBB.
+var ColourMap = func() []color.RGBA {
+	colors := make([]color.RGBA, 256)
+	for i := range colors {
+		colors[i] = color.RGBA{
+			R: uint8((i >> 5 & 0x7) * 0xff / 0x7),
+			G: uint8((i >> 2 & 0x7) * 0xff / 0x7),
+			B: uint8((i & 0x3) * 0xff / 0x3),
+			A: 0xff,
+		}
+	}
+	return colors
+}()
+
+func ParsePixelFormat(bs []byte) (PixelFormat, error) {
+	if len(bs) < PixelFormatSize {
+		return PixelFormat{}, InvalidPixelFormat
+	}
+
+	pf := PixelFormat{
+		BitsPerPixel: bs[0],
+		Depth:        bs[1],
+		BigEndian:    bs[2],
+		TrueColor:    bs[3],
+		RedMax:       binary.BigEndian.Uint16(bs[4:6]),
+		GreenMax:     binary.BigEndian.Uint16(bs[6:8]),
+		BlueMax:      binary.BigEndian.Uint16(bs[8:10]),
+		RedShift:     bs[10],
+		GreenShift:   bs[11],
+		BlueShift:    bs[12],
+	}
+
+	return pf, pf.Validate()
+}
+
+func (pf PixelFormat) Validate() error {
+	switch pf.BitsPerPixel {
+	case 8, 16, 32:
+	default:
+		return InvalidPixelFormat
+	}
+
+	if pf.Depth == 0 || pf.Depth > pf.BitsPerPixel {
+		return InvalidPixelFormat
+	}
+
+	if pf.IsTrueColor() && (pf.RedMax == 0 || pf.GreenMax == 0 || pf.BlueMax == 0) {
+		return InvalidPixelFormat
+	}
+
+	return nil
+}
+
+func (pf PixelFormat) Bytes() []byte {
+	return []byte{
+		pf.BitsPerPixel,
+		pf.Depth,
+		pf.BigEndian,
+		pf.TrueColor,
+		byte(pf.RedMax >> 8), byte(pf.RedMax),
+		byte(pf.GreenMax >> 8), byte(pf.GreenMax),
+		byte(pf.BlueMax >> 8), byte(pf.BlueMax),
+		pf.RedShift,
+		pf.GreenShift,
+		pf.BlueShift,
+		// padding
+		0x00, 0x00, 0x00,
+	}
+}
+
+func (pf PixelFormat) IsTrueColor() bool {
+	return pf.TrueColor != 0
+}
+
+func (pf PixelFormat) IsBigEndian() bool {
+	return pf.BigEndian != 0
+}
+
+func (pf PixelFormat) BytesPerPixel() int {
+	return int(pf.BitsPerPixel) / 8
+}
+
+// Pixel converts a color into the pixel value of this format
+func (pf PixelFormat) Pixel(c color.Color) uint32 {
+	r, g, b, _ := c.RGBA()
+	return pf.PixelRGB(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+}
+
+// PixelRGB converts 8-bit color components into the pixel value of this format
+func (pf PixelFormat) PixelRGB(r, g, b uint8) uint32 {
+	if !pf.IsTrueColor() {
+		return uint32(r>>5)<<5 | uint32(g>>5)<<2 | uint32(b>>6)
+	}
+	return scale(r, pf.RedMax)<<pf.RedShift |
+		scale(g, pf.GreenMax)<<pf.GreenShift |
+		scale(b, pf.BlueMax)<<pf.BlueShift
+}
+
+// AppendPixel appends the color c to dst in this format
+func (pf PixelFormat) AppendPixel(dst []byte, c color.Color) []byte {
+	return pf.AppendPixelValue(dst, pf.Pixel(c))
+}
+
+// AppendPixelValue appends a pixel value, which is returned by Pixel, to dst
+func (pf PixelFormat) AppendPixelValue(dst []byte, pixel uint32) []byte {
+	switch pf.BitsPerPixel {
+	case 8:
+		return append(dst, byte(pixel))
+	case 16:
+		if pf.IsBigEndian() {
+			return binary.BigEndian.AppendUint16(dst, uint16(pixel))
+		}
+		return binary.LittleEndian.AppendUint16(dst, uint16(pixel))
+	default:
+		if pf.IsBigEndian() {
+			return binary.BigEndian.AppendUint32(dst, pixel)
+		}
+		return binary.LittleEndian.AppendUint32(dst, pixel)
+	}
+}
+
+// AppendPixels appends all pixels of img to dst in this format, from left to right, top to bottom
+func (pf PixelFormat) AppendPixels(dst []byte, img image.Image) []byte {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dst = pf.AppendPixel(dst, img.At(x, y))
+		}
+	}
+	return dst
+}
+
+func scale(v uint8, max uint16) uint32 {
+	return (uint32(v)*uint32(max) + 0x7f) / 0xff
+}
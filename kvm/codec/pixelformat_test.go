@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestParsePixelFormat(t *testing.T) {
+	// 0000 0000 2018 0001 00ff 00ff 00ff 1008 0000 0000
+	bs := []byte{0x20, 0x18, 0x00, 0x01, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x10, 0x08, 0x00, 0x00, 0x00, 0x00}
+
+	pf, err := ParsePixelFormat(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pf != DefaultPixelFormat {
+		t.Fatalf("Expected %+v, got %+v", DefaultPixelFormat, pf)
+	}
+
+	if !slices.Equal(pf.Bytes(), bs) {
+		t.Fatalf("Expected %v, got %v", bs, pf.Bytes())
+	}
+
+	_, err = ParsePixelFormat(append([]byte{24}, bs[1:]...))
+	if err == nil {
+		t.Fatal("Expected error for 24 bits-per-pixel")
+	}
+}
+
+func TestAppendPixel(t *testing.T) {
+	orange := color.RGBA{R: 0xff, G: 0x80, B: 0x00, A: 0xff}
+
+	bs := DefaultPixelFormat.AppendPixel(nil, orange)
+	if !slices.Equal(bs, []byte{0x00, 0x80, 0xff, 0x00}) {
+		t.Fatalf("Expected [0 128 255 0], got %v", bs)
+	}
+
+	rgb565 := PixelFormat{
+		BitsPerPixel: 16, Depth: 16, BigEndian: 1, TrueColor: 1,
+		RedMax: 0x1f, GreenMax: 0x3f, BlueMax: 0x1f,
+		RedShift: 11, GreenShift: 5, BlueShift: 0,
+	}
+	bs = rgb565.AppendPixel(nil, orange)
+	if !slices.Equal(bs, []byte{0xfc, 0x00}) {
+		t.Fatalf("Expected [252 0], got %v", bs)
+	}
+
+	colourMap := PixelFormat{BitsPerPixel: 8, Depth: 8}
+	bs = colourMap.AppendPixel(nil, orange)
+	if !slices.Equal(bs, []byte{0xf0}) || ColourMap[bs[0]].R != 0xff {
+		t.Fatalf("Expected [240], got %v", bs)
+	}
+}
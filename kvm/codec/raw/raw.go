@@ -0,0 +1,36 @@
+package raw
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/helper"
+	"github.com/allape/openkvm/kvm/codec"
+)
+
+// Encoder
+// https://datatracker.ietf.org/doc/html/rfc6143#section-7.7.1
+type Encoder struct {
+	codec.Codec
+
+	SliceCount config.SliceCount
+}
+
+func (e *Encoder) Encoding() codec.Encoding {
+	return codec.Raw
+}
+
+func (e *Encoder) FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat codec.PixelFormat) ([]byte, error) {
+	rects, err := helper.CalcNextImageRects(previewFrame, nextFrame, e.SliceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := codec.AppendFramebufferUpdateHeader(nil, len(rects))
+
+	for _, rect := range rects {
+		size := rect.Frame.Bounds().Size()
+		payload = codec.AppendRectHeader(payload, int(rect.X), int(rect.Y), size.X, size.Y, codec.Raw)
+		payload = pixelFormat.AppendPixels(payload, rect.Frame)
+	}
+
+	return payload, nil
+}
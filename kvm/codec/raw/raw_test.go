@@ -0,0 +1,47 @@
+package raw
+
+import (
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	img.Set(1, 0, color.RGBA{G: 0xff, A: 0xff})
+	img.Set(0, 1, color.RGBA{B: 0xff, A: 0xff})
+	img.Set(1, 1, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+
+	encoder := &Encoder{SliceCount: 1}
+
+	bgr233 := codec.PixelFormat{
+		BitsPerPixel: 8, Depth: 8, TrueColor: 1,
+		RedMax: 0x7, GreenMax: 0x7, BlueMax: 0x3,
+		RedShift: 0, GreenShift: 3, BlueShift: 6,
+	}
+
+	payload, err := encoder.FramebufferUpdate(nil, img, bgr233)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		0, 0, 0, 1, // FramebufferUpdate with 1 rect
+		0, 0, 0, 0, 0, 2, 0, 2, 0, 0, 0, 0, // 2x2 at (0, 0) in raw
+		0x07, 0x38, 0xc0, 0xff,
+	}
+	if !slices.Equal(payload, expected) {
+		t.Fatalf("Expected %v, got %v", expected, payload)
+	}
+
+	payload, err = encoder.FramebufferUpdate(img, img, bgr233)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(payload, []byte{0, 0, 0, 0}) {
+		t.Fatalf("Expected FramebufferUpdate without rect, got %v", payload)
+	}
+}
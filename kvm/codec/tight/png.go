@@ -0,0 +1,69 @@
+package tight
+
+import (
+	"bytes"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/helper"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/png"
+)
+
+// PNGEncoder
+// TightPNG encoding of noVNC, rects are either filled with a single color or compressed as PNG.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tightpng-encoding
+type PNGEncoder struct {
+	codec.Codec
+
+	SliceCount config.SliceCount
+
+	encoder png.Encoder
+}
+
+func (e *PNGEncoder) Encoding() codec.Encoding {
+	return codec.TightPNG
+}
+
+func (e *PNGEncoder) FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat codec.PixelFormat) ([]byte, error) {
+	rects, err := helper.CalcNextImageRects(previewFrame, nextFrame, e.SliceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	rects = splitRects(rects, MaxRectWidth, MaxRectSize)
+
+	payload := codec.AppendFramebufferUpdateHeader(nil, len(rects))
+
+	for _, rect := range rects {
+		size := rect.Frame.Bounds().Size()
+		payload = codec.AppendRectHeader(payload, int(rect.X), int(rect.Y), size.X, size.Y, codec.TightPNG)
+		payload, err = e.appendRect(payload, rect.Frame, pixelFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+func (e *PNGEncoder) appendRect(dst []byte, img image.Image, pf codec.PixelFormat) ([]byte, error) {
+	pixels := pixelsOf(img, pf)
+	if palette := newPalette(pixels, 1); palette != nil {
+		dst = append(dst, FillCompression)
+		return appendTPixel(dst, palette.colors[0], pf), nil
+	}
+
+	if e.encoder.CompressionLevel == png.DefaultCompression {
+		// PNG is slow to encode, speed matters more on SBC
+		e.encoder.CompressionLevel = png.BestSpeed
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err := e.encoder.Encode(buffer, img)
+	if err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, PNGCompression)
+	return appendData(dst, buffer.Bytes())
+}
@@ -0,0 +1,52 @@
+package tight
+
+import (
+	"bytes"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func TestPNGEncoder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	encoder := &PNGEncoder{SliceCount: 1}
+
+	payload, err := encoder.FramebufferUpdate(nil, img, codec.DefaultPixelFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(payload[12:], []byte{0xff, 0xff, 0xfe, 0xfc, FillCompression, 0xff, 0xff, 0xff}) {
+		t.Fatalf("Expected fill rect, got %v", payload[12:])
+	}
+
+	img.Set(3, 4, color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff})
+
+	payload, err = encoder.FramebufferUpdate(nil, img, codec.DefaultPixelFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload[16] != PNGCompression {
+		t.Fatalf("Expected PNG rect, got %d", payload[16])
+	}
+
+	length, consumed := decodeLength(payload[17:])
+	decoded, err := png.Decode(bytes.NewReader(payload[17+consumed:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != len(payload)-17-consumed {
+		t.Fatalf("Expected length %d, got %d", len(payload)-17-consumed, length)
+	}
+
+	r, g, b, _ := decoded.At(3, 4).RGBA()
+	if r>>8 != 0x12 || g>>8 != 0x34 || b>>8 != 0x56 {
+		t.Fatalf("Unexpected pixel (%d, %d, %d)", r>>8, g>>8, b>>8)
+	}
+}
@@ -2,58 +2,378 @@ package tight
 
 import (
 	"bytes"
+	"compress/zlib"
+	"errors"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/helper"
 	"github.com/allape/openkvm/kvm/codec"
+	"image"
 	"image/jpeg"
 )
 
-type JPEGEncoder struct {
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
+
+const (
+	// MinToCompress data smaller than this will be sent without zlib compression
+	MinToCompress = 12
+	// MaxRectWidth is the max width of a rect which can be decoded by clients
+	MaxRectWidth = 2048
+	// MaxRectSize is the max pixels of a rect, same as TightVNC,
+	// so that the compressed data of a rect never exceeds MaxDataSize
+	MaxRectSize = 65536
+	// MaxDataSize is the max length of compressed data, which is the max value of compact length
+	MaxDataSize = 1<<22 - 1
+	// DefaultJPEGQuality is used if Quality is 0
+	DefaultJPEGQuality = 75
+	// MaxPaletteSize rects with more colors than this are treated as photographic content
+	MaxPaletteSize = 256
+)
+
+// compression-control
+const (
+	BasicCompression byte = 0x00
+	FillCompression  byte = 0x80
+	JPEGCompression  byte = 0x90
+	PNGCompression   byte = 0xA0
+
+	ExplicitFilter byte = 0x40
+)
+
+// filter-id
+const (
+	CopyFilter     byte = 0
+	PaletteFilter  byte = 1
+	GradientFilter byte = 2
+)
+
+// zlib stream of each kind of data
+const (
+	FullColorStream = 0
+	MonoStream      = 1
+	IndexedStream   = 2
+	GradientStream  = 3
+)
+
+var DataTooLarge = errors.New("compressed data of tight rect is too large")
+
+// JPEGQualityLevels maps JPEG quality level pseudo-encodings to JPEG quality
+var JPEGQualityLevels = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+// Encoder holds 4 zlib streams which live as long as the connection,
+// so an Encoder can NOT be shared between clients.
+type Encoder struct {
 	codec.Codec
 
+	// Quality is the default and max quality of JPEG,
+	// 0 means DefaultJPEGQuality,
+	// negative means JPEG is only used when client sends a JPEG quality level.
 	Quality    int
 	SliceCount config.SliceCount
+
+	// jpegQuality is the quality level sent by client, 0 if not sent
+	jpegQuality      int
+	compressionLevel int
+
+	streams      [4]*zlib.Writer
+	buffers      [4]*bytes.Buffer
+	resetStreams byte
 }
 
-func (e *JPEGEncoder) FramebufferUpdate(previewFrame, nextFrame config.Frame) ([]byte, error) {
+func (e *Encoder) Encoding() codec.Encoding {
+	return codec.Tight
+}
+
+func (e *Encoder) SetPseudoEncodings(encodings []codec.Encoding) {
+	e.jpegQuality = 0
+	compressionLevel := zlib.DefaultCompression
+
+	for _, encoding := range encodings {
+		switch {
+		case encoding >= codec.JPEGQualityLevel0 && encoding <= codec.JPEGQualityLevel9:
+			e.jpegQuality = JPEGQualityLevels[encoding-codec.JPEGQualityLevel0]
+		case encoding >= codec.CompressionLevel0 && encoding <= codec.CompressionLevel9:
+			compressionLevel = int(encoding - codec.CompressionLevel0)
+			if compressionLevel == 0 {
+				compressionLevel = zlib.BestSpeed
+			}
+		}
+	}
+
+	if compressionLevel != e.compressionLevel {
+		e.compressionLevel = compressionLevel
+		// streams will be re-created with the new level, and reset on client side
+		for i := range e.streams {
+			if e.streams[i] != nil {
+				e.streams[i] = nil
+				e.resetStreams |= 1 << i
+			}
+		}
+	}
+}
+
+func (e *Encoder) FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat codec.PixelFormat) ([]byte, error) {
 	rects, err := helper.CalcNextImageRects(previewFrame, nextFrame, e.SliceCount)
 	if err != nil {
 		return nil, err
 	}
 
-	count := len(rects)
-	var payload = []byte{
-		0,                             // FramebufferUpdate
-		0,                             // padding
-		byte(count >> 8), byte(count), // Number of rectangles
-	}
+	rects = splitRects(rects, MaxRectWidth, MaxRectSize)
 
-	options := &jpeg.Options{Quality: e.Quality}
-	if options.Quality == 0 {
-		options.Quality = 75
-	}
+	payload := codec.AppendFramebufferUpdateHeader(nil, len(rects))
 
 	for _, rect := range rects {
 		size := rect.Frame.Bounds().Size()
-		payload = append(payload, []byte{
-			byte(rect.X >> 8), byte(rect.X), // x-position
-			byte(rect.Y >> 8), byte(rect.Y), // y-position
-			byte(size.X >> 8), byte(size.X), // width
-			byte(size.Y >> 8), byte(size.Y), // height
-			0, 0, 0, 7, // encoding-type, tight
-			0x90, // jpeg rect
-		}...)
-		buffer := bytes.NewBuffer(nil)
-		err := jpeg.Encode(buffer, rect.Frame, options)
+		payload = codec.AppendRectHeader(payload, int(rect.X), int(rect.Y), size.X, size.Y, codec.Tight)
+		payload, err = e.appendRect(payload, rect.Frame, pixelFormat)
 		if err != nil {
 			return nil, err
 		}
-		payload = append(payload, encodeLength(buffer.Len())...) // size
-		payload = append(payload, buffer.Bytes()...)             // data
 	}
+
 	return payload, nil
 }
 
+func (e *Encoder) appendRect(dst []byte, img image.Image, pf codec.PixelFormat) ([]byte, error) {
+	pixels := pixelsOf(img, pf)
+	palette := newPalette(pixels, MaxPaletteSize)
+
+	// reset flags can be sent along with any kind of compression
+	reset := e.resetStreams
+	e.resetStreams = 0
+
+	switch {
+	case palette != nil && len(palette.colors) == 1:
+		dst = append(dst, FillCompression|reset)
+		return appendTPixel(dst, palette.colors[0], pf), nil
+	case palette != nil:
+		stream := IndexedStream
+		if len(palette.colors) == 2 {
+			stream = MonoStream
+		}
+		dst = append(dst, BasicCompression|ExplicitFilter|byte(stream)<<4|reset, PaletteFilter, byte(len(palette.colors)-1))
+		for _, c := range palette.colors {
+			dst = appendTPixel(dst, c, pf)
+		}
+		return e.appendZlib(dst, stream, palette.indexes(pixels, img.Bounds().Dx()))
+	case e.quality() > 0 && IsJPEGSupported(pf):
+		dst = append(dst, JPEGCompression|reset)
+		return appendJPEG(dst, img, e.quality())
+	case IsTPixel(pf):
+		dst = append(dst, BasicCompression|ExplicitFilter|GradientStream<<4|reset, GradientFilter)
+		return e.appendZlib(dst, GradientStream, gradient(pixels, img.Bounds().Dx(), pf))
+	default:
+		dst = append(dst, BasicCompression|FullColorStream<<4|reset)
+		data := make([]byte, 0, len(pixels)*pf.BytesPerPixel())
+		for _, pixel := range pixels {
+			data = appendTPixel(data, pixel, pf)
+		}
+		return e.appendZlib(dst, FullColorStream, data)
+	}
+}
+
+// quality returns the JPEG quality to use, 0 means JPEG is NOT used
+func (e *Encoder) quality() int {
+	if e.Quality < 0 {
+		return e.jpegQuality
+	}
+
+	quality := e.Quality
+	if quality == 0 {
+		quality = DefaultJPEGQuality
+	}
+	if e.jpegQuality > 0 && e.jpegQuality < quality {
+		return e.jpegQuality
+	}
+	return quality
+}
+
+// appendZlib appends data compressed with zlib stream,
+// data smaller than MinToCompress is appended as is.
+func (e *Encoder) appendZlib(dst []byte, stream int, data []byte) ([]byte, error) {
+	if len(data) < MinToCompress {
+		return append(dst, data...), nil
+	}
+
+	var err error
+
+	if e.streams[stream] == nil {
+		level := e.compressionLevel
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		e.buffers[stream] = bytes.NewBuffer(nil)
+		e.streams[stream], err = zlib.NewWriterLevel(e.buffers[stream], level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = e.streams[stream].Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = e.streams[stream].Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := e.buffers[stream]
+	defer buffer.Reset()
+
+	return appendData(dst, buffer.Bytes())
+}
+
+// IsJPEGSupported JPEG compression is only allowed for true color clients with 16 or 32 bits-per-pixel
+func IsJPEGSupported(pf codec.PixelFormat) bool {
+	return pf.IsTrueColor() && (pf.BitsPerPixel == 16 || pf.BitsPerPixel == 32)
+}
+
+// IsTPixel TPIXEL is 3 bytes of R, G, B, which is used instead of PIXEL
+// when the client is in 32bpp true color with depth of 24 and 8-bit color components.
+func IsTPixel(pf codec.PixelFormat) bool {
+	return pf.IsTrueColor() && pf.BitsPerPixel == 32 && pf.Depth == 24 &&
+		pf.RedMax == 0xff && pf.GreenMax == 0xff && pf.BlueMax == 0xff
+}
+
+func appendTPixel(dst []byte, pixel uint32, pf codec.PixelFormat) []byte {
+	if !IsTPixel(pf) {
+		return pf.AppendPixelValue(dst, pixel)
+	}
+	return append(dst, byte(pixel>>pf.RedShift), byte(pixel>>pf.GreenShift), byte(pixel>>pf.BlueShift))
+}
+
+func appendJPEG(dst []byte, img image.Image, quality int) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, err
+	}
+	return appendData(dst, buffer.Bytes())
+}
+
+// appendData appends the compact length and compressed data
+func appendData(dst []byte, data []byte) ([]byte, error) {
+	if len(data) > MaxDataSize {
+		return nil, DataTooLarge
+	}
+	dst = append(dst, encodeLength(len(data))...) // size
+	return append(dst, data...), nil              // data
+}
+
+func pixelsOf(img image.Image, pf codec.PixelFormat) []uint32 {
+	bounds := img.Bounds()
+	pixels := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixels = append(pixels, pf.Pixel(img.At(x, y)))
+		}
+	}
+	return pixels
+}
+
+type palette struct {
+	colors []uint32
+	index  map[uint32]byte
+}
+
+// newPalette returns nil if there are more than maxSize colors in pixels
+func newPalette(pixels []uint32, maxSize int) *palette {
+	p := &palette{index: make(map[uint32]byte)}
+	for _, pixel := range pixels {
+		if _, ok := p.index[pixel]; ok {
+			continue
+		}
+		if len(p.colors) == maxSize {
+			return nil
+		}
+		p.index[pixel] = byte(len(p.colors))
+		p.colors = append(p.colors, pixel)
+	}
+	return p
+}
+
+// indexes returns 1 bit per pixel for 2 colors, with each row padded to byte;
+// otherwise, 1 byte per pixel
+func (p *palette) indexes(pixels []uint32, width int) []byte {
+	if len(p.colors) != 2 {
+		data := make([]byte, len(pixels))
+		for i, pixel := range pixels {
+			data[i] = p.index[pixel]
+		}
+		return data
+	}
+
+	height := len(pixels) / width
+	rowSize := (width + 7) / 8
+	data := make([]byte, rowSize*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if p.index[pixels[y*width+x]] == 1 {
+				data[y*rowSize+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return data
+}
+
+// gradient filter for TPIXEL, each component is predicted by left + above - above-left
+func gradient(pixels []uint32, width int, pf codec.PixelFormat) []byte {
+	shifts := [3]uint8{pf.RedShift, pf.GreenShift, pf.BlueShift}
+	data := make([]byte, len(pixels)*3)
+
+	component := func(x, y, c int) int {
+		if x < 0 || y < 0 {
+			return 0
+		}
+		return int(pixels[y*width+x] >> shifts[c] & 0xff)
+	}
+
+	height := len(pixels) / width
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				prediction := component(x-1, y, c) + component(x, y-1, c) - component(x-1, y-1, c)
+				prediction = max(0, min(0xff, prediction))
+				data[(y*width+x)*3+c] = byte(component(x, y, c) - prediction)
+			}
+		}
+	}
+	return data
+}
+
+// splitRects splits rects which are wider than maxWidth into columns,
+// then splits columns with more than maxSize pixels into rows
+func splitRects(rects []config.Rect, maxWidth, maxSize int) []config.Rect {
+	result := make([]config.Rect, 0, len(rects))
+	for _, rect := range rects {
+		bounds := rect.Frame.Bounds()
+		if bounds.Dx() <= maxWidth && bounds.Dx()*bounds.Dy() <= maxSize {
+			result = append(result, rect)
+			continue
+		}
+
+		img, ok := rect.Frame.(helper.SubImager)
+		if !ok {
+			result = append(result, rect)
+			continue
+		}
+
+		for x := bounds.Min.X; x < bounds.Max.X; x += maxWidth {
+			width := min(maxWidth, bounds.Max.X-x)
+			height := max(1, maxSize/width)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y += height {
+				sub := image.Rect(x, y, x+width, min(y+height, bounds.Max.Y))
+				result = append(result, config.Rect{
+					X:     rect.X + uint64(x-bounds.Min.X),
+					Y:     rect.Y + uint64(y-bounds.Min.Y),
+					Frame: img.SubImage(sub),
+				})
+			}
+		}
+	}
+	return result
+}
+
 func decodeLength(aob []byte) (int, int) {
 	b := aob[0]
 	consumedLength := 1
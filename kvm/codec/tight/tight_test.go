@@ -1,6 +1,14 @@
 package tight
 
 import (
+	"bytes"
+	"compress/zlib"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
 	"slices"
 	"testing"
 )
@@ -64,3 +72,137 @@ func TestDecodeLength(t *testing.T) {
 		t.Fatalf("Expected (26417, 3), got (%d, %d)", length, consumedLength)
 	}
 }
+
+func TestEncoder(t *testing.T) {
+	pf := codec.DefaultPixelFormat
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}), image.Point{}, draw.Src)
+
+	encoder := &Encoder{SliceCount: 1}
+	encoder.SetPseudoEncodings([]codec.Encoding{codec.Tight, codec.JPEGQualityLevel0 + 9})
+
+	payload, err := encoder.FramebufferUpdate(nil, img, pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 0, 0, 1,
+		0, 0, 0, 0, 0, 16, 0, 8, 0, 0, 0, 7,
+		FillCompression, 0x12, 0x34, 0x56,
+	}
+	if !slices.Equal(payload, expected) {
+		t.Fatalf("Expected fill rect %v, got %v", expected, payload)
+	}
+
+	// white text on black console
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for x := 2; x < 12; x++ {
+		img.Set(x, 3, color.White)
+	}
+
+	var stream []byte
+	for i := 0; i < 2; i++ {
+		payload, err = encoder.FramebufferUpdate(nil, img, pf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := payload[16:25]
+		if !slices.Equal(header, []byte{ExplicitFilter | MonoStream<<4, PaletteFilter, 1, 0, 0, 0, 0xff, 0xff, 0xff}) {
+			t.Fatalf("Unexpected mono rect %v", header)
+		}
+		length, consumed := decodeLength(payload[25:])
+		if length != len(payload)-25-consumed {
+			t.Fatalf("Expected length %d, got %d", len(payload)-25-consumed, length)
+		}
+		stream = append(stream, payload[25+consumed:]...)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2*16)
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(data[:16], []byte{0, 0, 0, 0, 0, 0, 0b00111111, 0b11110000, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Fatalf("Unexpected mono bitmap %v", data[:16])
+	}
+}
+
+func TestGradient(t *testing.T) {
+	pf := codec.DefaultPixelFormat
+	pixels := []uint32{
+		pf.PixelRGB(10, 0, 0), pf.PixelRGB(20, 0, 0),
+		pf.PixelRGB(30, 0, 0), pf.PixelRGB(35, 0, 0),
+	}
+
+	data := gradient(pixels, 2, pf)
+	// 10 - 0, 20 - 10, 30 - 10, 35 - (30 + 20 - 10)
+	expected := []byte{10, 0, 0, 10, 0, 0, 20, 0, 0, 0xfb, 0, 0}
+	if !slices.Equal(data, expected) {
+		t.Fatalf("Expected %v, got %v", expected, data)
+	}
+}
+
+func TestSplitRects(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 5000, 10))
+
+	rects := splitRects([]config.Rect{{X: 100, Y: 20, Frame: img}}, MaxRectWidth, MaxRectSize)
+	if len(rects) != 3 {
+		t.Fatalf("Expected 3 rects, got %d", len(rects))
+	}
+	if rects[2].X != 100+2*MaxRectWidth || rects[2].Frame.Bounds().Dx() != 5000-2*MaxRectWidth {
+		t.Fatalf("Unexpected last rect at %d with width %d", rects[2].X, rects[2].Frame.Bounds().Dx())
+	}
+
+	// 1920x1080 is split into 31 rows of 1920x34 and the last one of 1920x26
+	img = image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	rects = splitRects([]config.Rect{{X: 0, Y: 0, Frame: img}}, MaxRectWidth, MaxRectSize)
+	if len(rects) != 32 {
+		t.Fatalf("Expected 32 rects, got %d", len(rects))
+	}
+	for _, rect := range rects {
+		size := rect.Frame.Bounds().Size()
+		if size.X*size.Y > MaxRectSize {
+			t.Fatalf("Rect at %d has %d pixels, more than %d", rect.Y, size.X*size.Y, MaxRectSize)
+		}
+	}
+	if last := rects[31]; last.Y != 31*34 || last.Frame.Bounds().Dy() != 1080-31*34 {
+		t.Fatalf("Unexpected last rect at %d with height %d", last.Y, last.Frame.Bounds().Dy())
+	}
+}
+
+func TestQuality(t *testing.T) {
+	encoder := &Encoder{}
+	if q := encoder.quality(); q != DefaultJPEGQuality {
+		t.Fatalf("Expected default quality %d, got %d", DefaultJPEGQuality, q)
+	}
+
+	encoder.Quality = 80
+	if q := encoder.quality(); q != 80 {
+		t.Fatalf("Expected configured quality 80, got %d", q)
+	}
+
+	encoder.SetPseudoEncodings([]codec.Encoding{codec.JPEGQualityLevel0 + 2})
+	if q := encoder.quality(); q != JPEGQualityLevels[2] {
+		t.Fatalf("Expected quality of level 2 %d, got %d", JPEGQualityLevels[2], q)
+	}
+
+	// capped by configured quality
+	encoder.SetPseudoEncodings([]codec.Encoding{codec.JPEGQualityLevel0 + 9})
+	if q := encoder.quality(); q != 80 {
+		t.Fatalf("Expected capped quality 80, got %d", q)
+	}
+
+	encoder = &Encoder{Quality: -1}
+	if q := encoder.quality(); q != 0 {
+		t.Fatalf("Expected no JPEG, got %d", q)
+	}
+	encoder.SetPseudoEncodings([]codec.Encoding{codec.JPEGQualityLevel0 + 9})
+	if q := encoder.quality(); q != 100 {
+		t.Fatalf("Expected quality of level 9, got %d", q)
+	}
+}
@@ -0,0 +1,287 @@
+package zrle
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/helper"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc6143#section-7.7.6
+
+const (
+	TileSize = 64
+
+	MaxPaletteSize       = 127
+	MaxPackedPaletteSize = 16
+)
+
+const (
+	RawTile         byte = 0
+	SolidTile       byte = 1
+	PlainRLETile    byte = 128
+	PaletteRLETiles byte = 128 // + palette size
+)
+
+// Encoder holds a zlib stream which lives as long as the connection,
+// so an Encoder can NOT be shared between clients.
+type Encoder struct {
+	codec.Codec
+
+	SliceCount config.SliceCount
+	Level      int
+
+	buffer *bytes.Buffer
+	writer *zlib.Writer
+}
+
+func (e *Encoder) Encoding() codec.Encoding {
+	return codec.ZRLE
+}
+
+func (e *Encoder) FramebufferUpdate(previewFrame, nextFrame config.Frame, pixelFormat codec.PixelFormat) ([]byte, error) {
+	rects, err := helper.CalcNextImageRects(previewFrame, nextFrame, e.SliceCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.writer == nil {
+		level := e.Level
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		e.buffer = bytes.NewBuffer(nil)
+		e.writer, err = zlib.NewWriterLevel(e.buffer, level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payload := codec.AppendFramebufferUpdateHeader(nil, len(rects))
+
+	for _, rect := range rects {
+		size := rect.Frame.Bounds().Size()
+		payload = codec.AppendRectHeader(payload, int(rect.X), int(rect.Y), size.X, size.Y, codec.ZRLE)
+
+		_, err = e.writer.Write(appendTiles(nil, rect.Frame, pixelFormat))
+		if err != nil {
+			return nil, err
+		}
+		err = e.writer.Flush()
+		if err != nil {
+			return nil, err
+		}
+
+		payload = binary.BigEndian.AppendUint32(payload, uint32(e.buffer.Len())) // length
+		payload = append(payload, e.buffer.Bytes()...)                           // zlibData
+		e.buffer.Reset()
+	}
+
+	return payload, nil
+}
+
+// appendTiles appends the uncompressed tiles of img, from left to right, top to bottom
+func appendTiles(dst []byte, img image.Image, pf codec.PixelFormat) []byte {
+	bounds := img.Bounds()
+	pixels := make([]uint32, 0, TileSize*TileSize)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += TileSize {
+		for x := bounds.Min.X; x < bounds.Max.X; x += TileSize {
+			tile := image.Rect(x, y, x+TileSize, y+TileSize).Intersect(bounds)
+
+			pixels = pixels[:0]
+			for ty := tile.Min.Y; ty < tile.Max.Y; ty++ {
+				for tx := tile.Min.X; tx < tile.Max.X; tx++ {
+					pixels = append(pixels, pf.Pixel(img.At(tx, ty)))
+				}
+			}
+
+			dst = appendTile(dst, pixels, tile.Dx(), pf)
+		}
+	}
+
+	return dst
+}
+
+type run struct {
+	pixel  uint32
+	length int
+}
+
+// appendTile appends one tile with the sub-encoding which produces the least bytes
+func appendTile(dst []byte, pixels []uint32, width int, pf codec.PixelFormat) []byte {
+	cpixel := newCPixel(pf)
+
+	palette := make(map[uint32]byte)
+	colors := make([]uint32, 0, MaxPaletteSize)
+	var runs []run
+
+	for _, pixel := range pixels {
+		if len(runs) > 0 && runs[len(runs)-1].pixel == pixel {
+			runs[len(runs)-1].length++
+		} else {
+			runs = append(runs, run{pixel: pixel, length: 1})
+		}
+		if _, ok := palette[pixel]; !ok && len(colors) <= MaxPaletteSize {
+			palette[pixel] = byte(len(colors))
+			colors = append(colors, pixel)
+		}
+	}
+
+	if len(colors) == 1 {
+		return cpixel.append(append(dst, SolidTile), colors[0])
+	}
+
+	height := len(pixels) / width
+
+	rawSize := len(pixels) * cpixel.size
+	plainRLESize := 0
+	paletteRLESize := 0
+	for _, r := range runs {
+		plainRLESize += cpixel.size + runLengthSize(r.length)
+		paletteRLESize += 1
+		if r.length > 1 {
+			paletteRLESize += runLengthSize(r.length)
+		}
+	}
+
+	bestSize := rawSize
+	best := RawTile
+	if plainRLESize < bestSize {
+		bestSize = plainRLESize
+		best = PlainRLETile
+	}
+
+	usePalette := len(colors) <= MaxPaletteSize
+	if usePalette {
+		paletteRLESize += len(colors) * cpixel.size
+		if paletteRLESize < bestSize {
+			bestSize = paletteRLESize
+			best = PaletteRLETiles + byte(len(colors))
+		}
+		if len(colors) <= MaxPackedPaletteSize {
+			packedSize := len(colors)*cpixel.size + (width*packedBits(len(colors))+7)/8*height
+			if packedSize < bestSize {
+				best = byte(len(colors))
+			}
+		}
+	}
+
+	dst = append(dst, best)
+
+	switch {
+	case best == RawTile:
+		for _, pixel := range pixels {
+			dst = cpixel.append(dst, pixel)
+		}
+	case best == PlainRLETile:
+		for _, r := range runs {
+			dst = cpixel.append(dst, r.pixel)
+			dst = appendRunLength(dst, r.length)
+		}
+	case best > PaletteRLETiles:
+		for _, c := range colors {
+			dst = cpixel.append(dst, c)
+		}
+		for _, r := range runs {
+			if r.length == 1 {
+				dst = append(dst, palette[r.pixel])
+				continue
+			}
+			dst = append(dst, palette[r.pixel]|0x80)
+			dst = appendRunLength(dst, r.length)
+		}
+	default:
+		for _, c := range colors {
+			dst = cpixel.append(dst, c)
+		}
+		bits := packedBits(len(colors))
+		for y := 0; y < height; y++ {
+			var b byte
+			shift := 8
+			for x := 0; x < width; x++ {
+				shift -= bits
+				b |= palette[pixels[y*width+x]] << shift
+				if shift == 0 {
+					dst = append(dst, b)
+					b = 0
+					shift = 8
+				}
+			}
+			if shift != 8 {
+				dst = append(dst, b)
+			}
+		}
+	}
+
+	return dst
+}
+
+func packedBits(paletteSize int) int {
+	switch {
+	case paletteSize <= 2:
+		return 1
+	case paletteSize <= 4:
+		return 2
+	default:
+		return 4
+	}
+}
+
+func runLengthSize(length int) int {
+	return (length-1)/255 + 1
+}
+
+// appendRunLength appends length - 1 as a sequence of 255 and a final byte less than 255
+func appendRunLength(dst []byte, length int) []byte {
+	length--
+	for length >= 255 {
+		dst = append(dst, 255)
+		length -= 255
+	}
+	return append(dst, byte(length))
+}
+
+// cPixel is PIXEL in 3 bytes, if the pixel format is 32bpp true color with depth 24 or less,
+// and the color bits fit in the least or most significant 3 bytes.
+type cPixel struct {
+	pf   codec.PixelFormat
+	size int
+	// offset of the 3 bytes in PIXEL
+	offset int
+}
+
+func newCPixel(pf codec.PixelFormat) cPixel {
+	cp := cPixel{pf: pf, size: pf.BytesPerPixel()}
+
+	if !pf.IsTrueColor() || pf.BitsPerPixel != 32 || pf.Depth > 24 {
+		return cp
+	}
+
+	mask := pf.PixelRGB(0xff, 0xff, 0xff)
+	switch {
+	case mask&0xff000000 == 0:
+		cp.size = 3
+		if pf.IsBigEndian() {
+			cp.offset = 1
+		}
+	case mask&0x000000ff == 0:
+		cp.size = 3
+		if !pf.IsBigEndian() {
+			cp.offset = 1
+		}
+	}
+
+	return cp
+}
+
+func (cp cPixel) append(dst []byte, pixel uint32) []byte {
+	if cp.size != 3 {
+		return cp.pf.AppendPixelValue(dst, pixel)
+	}
+	bs := cp.pf.AppendPixelValue(make([]byte, 0, 4), pixel)
+	return append(dst, bs[cp.offset:cp.offset+3]...)
+}
@@ -0,0 +1,112 @@
+package zrle
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"github.com/allape/openkvm/kvm/codec"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestAppendTile(t *testing.T) {
+	pf := codec.DefaultPixelFormat
+
+	black := pf.PixelRGB(0, 0, 0)
+	white := pf.PixelRGB(0xff, 0xff, 0xff)
+
+	bs := appendTile(nil, []uint32{white, white, white, white}, 2, pf)
+	if !slices.Equal(bs, []byte{SolidTile, 0xff, 0xff, 0xff}) {
+		t.Fatalf("Expected solid tile, got %v", bs)
+	}
+
+	// 8x2 checkerboard, packed palette with 1 bit per pixel
+	pixels := make([]uint32, 16)
+	for i := range pixels {
+		if (i+i/8)%2 == 0 {
+			pixels[i] = white
+		} else {
+			pixels[i] = black
+		}
+	}
+	bs = appendTile(nil, pixels, 8, pf)
+	if !slices.Equal(bs, []byte{2, 0xff, 0xff, 0xff, 0, 0, 0, 0b01010101, 0b10101010}) {
+		t.Fatalf("Expected packed palette tile, got %v", bs)
+	}
+
+	// long runs of two colors, plain RLE
+	pixels = make([]uint32, 64*64)
+	for i := range pixels {
+		if i < 1000 {
+			pixels[i] = white
+		} else {
+			pixels[i] = black
+		}
+	}
+	bs = appendTile(nil, pixels, 64, pf)
+	expected := []byte{PlainRLETile, 0xff, 0xff, 0xff, 255, 255, 255, 234, 0, 0, 0}
+	expected = appendRunLength(expected, 64*64-1000)
+	if !slices.Equal(bs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, bs)
+	}
+
+	// runs of 17 colors, palette RLE
+	for i := range pixels {
+		c := uint8(i / 10 % 17)
+		pixels[i] = pf.PixelRGB(c, c, c)
+	}
+	bs = appendTile(nil, pixels, 64, pf)
+	if bs[0] != PaletteRLETiles+17 {
+		t.Fatalf("Expected palette RLE tile, got %d", bs[0])
+	}
+	if !slices.Equal(bs[1+17*3:1+17*3+4], []byte{0x80, 9, 0x81, 9}) {
+		t.Fatalf("Unexpected runs %v", bs[1+17*3:1+17*3+4])
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 70))
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 0xff})
+		}
+	}
+
+	encoder := &Encoder{SliceCount: 1}
+
+	var stream []byte
+	for i := 0; i < 2; i++ {
+		payload, err := encoder.FramebufferUpdate(nil, img, codec.DefaultPixelFormat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(payload[:16], []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 100, 0, 70, 0, 0, 0, 16}) {
+			t.Fatalf("Unexpected header %v", payload[:16])
+		}
+		length := binary.BigEndian.Uint32(payload[16:20])
+		if int(length) != len(payload)-20 {
+			t.Fatalf("Expected length %d, got %d", len(payload)-20, length)
+		}
+		stream = append(stream, payload[20:]...)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every pixel is different, 4 raw tiles per update: 64x64, 36x64, 64x6, 36x6
+	data, err := io.ReadAll(reader)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	if len(data) != 2*(4+100*70*3) {
+		t.Fatalf("Expected %d bytes in zlib stream, got %d", 2*(4+100*70*3), len(data))
+	}
+	if data[0] != RawTile || !slices.Equal(data[1:4], []byte{0, 0, 0}) || !slices.Equal(data[4:7], []byte{0, 0, 1}) {
+		t.Fatalf("Unexpected first tile %v", data[:7])
+	}
+}
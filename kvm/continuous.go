@@ -0,0 +1,42 @@
+package kvm
+
+// handleEnableContinuousUpdates
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#enablecontinuousupdates
+func (s *Server) handleEnableContinuousUpdates(client *Client) error {
+	err := client.Read(client.enableContinuousUpdates)
+	if err != nil {
+		return err
+	}
+
+	enable := client.enableContinuousUpdates[0] != 0
+	region := parseRect(client.enableContinuousUpdates[1:9])
+
+	l.Debug().Println("EnableContinuousUpdates:", enable, region)
+
+	defer client.wake()
+
+	client.updateLocker.Lock()
+	defer client.updateLocker.Unlock()
+
+	client.continuousUpdatesEnabled = enable
+	client.continuousUpdatesRegion = region
+
+	if !enable {
+		// no more update will be sent after this, because updateLocker is held
+		return s.sendEndOfContinuousUpdates(client)
+	}
+
+	return nil
+}
+
+// sendEndOfContinuousUpdates
+//
+//	+--------------+--------------+--------------+
+//	| No. of bytes | Type [Value] | Description  |
+//	+--------------+--------------+--------------+
+//	| 1            | U8 [150]     | message-type |
+//	+--------------+--------------+--------------+
+func (s *Server) sendEndOfContinuousUpdates(client *Client) error {
+	_, err := client.Write([]byte{byte(EndOfContinuousUpdates)})
+	return err
+}
@@ -0,0 +1,156 @@
+package kvm
+
+import (
+	"encoding/binary"
+	"github.com/allape/openkvm/config"
+	"time"
+)
+
+// DefaultControlTimeout how long the controller can be idle before others can take control
+const DefaultControlTimeout = 30 * time.Second
+
+// ClientInfo is a snapshot of a client
+type ClientInfo struct {
+	ID             uint64             `json:"id"`
+	Username       string             `json:"username"`
+	RemoteAddr     string             `json:"remote_addr"`
+	ConnectedAt    time.Time          `json:"connected_at"`
+	LastInputAt    time.Time          `json:"last_input_at"`
+	LastActivityAt time.Time          `json:"last_activity_at"`
+	BytesSent      uint64             `json:"bytes_sent"`
+	Permissions    config.Permissions `json:"permissions"`
+	Controller     bool               `json:"controller"`
+}
+
+func (s *Server) controlTimeout() time.Duration {
+	timeout := time.Duration(s.Options.Config.VNC.ControlTimeout) * time.Second
+	if timeout == 0 {
+		timeout = DefaultControlTimeout
+	}
+	return timeout
+}
+
+// withControl runs fn if client holds the control, or takes the control when the controller is idle.
+// Input of view-only clients is dropped silently.
+// There is no way for a client to ask for control, it is only passed by idle timeout or SetController.
+func (s *Server) withControl(client *Client, fn func() error) error {
+	s.controlLocker.Lock()
+	defer s.controlLocker.Unlock()
+
+	now := time.Now()
+
+	if s.controller != client {
+		if s.controller != nil && now.Sub(s.controller.lastInputAt) < s.controlTimeout() {
+			l.Verbose().Printf("Drop input of view-only client %d", client.ID)
+			return nil
+		}
+		l.Info().Printf("Client %d takes control", client.ID)
+		s.switchController(client)
+	}
+
+	client.lastInputAt = now
+
+	return fn()
+}
+
+// switchController releases keys and buttons held by the current controller, then gives control to client.
+// s.controlLocker should be held by caller.
+func (s *Server) switchController(client *Client) {
+	if s.controller != nil {
+		s.releaseInputs(s.controller)
+	}
+	s.controller = client
+	if client != nil {
+		client.lastInputAt = time.Now()
+	}
+}
+
+// releaseInputs sends key up and button up events for what client is holding,
+// otherwise they are stuck on the target machine.
+func (s *Server) releaseInputs(client *Client) {
+	if s.Keyboard != nil {
+		for key := range client.pressedKeys {
+			event := []byte{byte(KeyEvent), 0, 0, 0}
+			event = binary.BigEndian.AppendUint32(event, key)
+			err := s.Keyboard.SendKeyEvent(event)
+			if err != nil {
+				l.Warn().Println("Release key:", err)
+			}
+		}
+	}
+	clear(client.pressedKeys)
+
+	if s.Mouse != nil && client.lastPointerEvent != nil && client.lastPointerEvent[1] != 0 {
+		event := append([]byte{}, client.lastPointerEvent...)
+		event[1] = 0
+		err := s.Mouse.SendPointerEvent(event)
+		if err != nil {
+			l.Warn().Println("Release buttons:", err)
+		}
+	}
+	client.lastPointerEvent = nil
+}
+
+// Controller returns the client which holds the control, nil if no one
+func (s *Server) Controller() *ClientInfo {
+	s.controlLocker.Lock()
+	defer s.controlLocker.Unlock()
+
+	if s.controller == nil {
+		return nil
+	}
+
+	info := s.controller.info()
+	return &info
+}
+
+// SetController gives control to the client with id, 0 to release control from anyone
+func (s *Server) SetController(id uint64) error {
+	// client is looked up under controlLocker, see leave
+	s.controlLocker.Lock()
+	defer s.controlLocker.Unlock()
+
+	var client *Client
+	if id != 0 {
+		client = s.findClient(id)
+		if client == nil {
+			return ClientNotFound
+		}
+		if !client.Can(config.PermissionInput) {
+			return PermissionDenied
+		}
+	}
+
+	l.Info().Printf("Control is given to client %d", id)
+	s.switchController(client)
+
+	return nil
+}
+
+func (s *Server) findClient(id uint64) *Client {
+	s.clientsLocker.Lock()
+	defer s.clientsLocker.Unlock()
+
+	for c := range s.clients {
+		if c.ID == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// info s.controlLocker should be held by caller, because of lastInputAt
+func (c *Client) info() ClientInfo {
+	return ClientInfo{
+		ID:          c.ID,
+		Username:    c.Username,
+		RemoteAddr:  c.RemoteAddr,
+		ConnectedAt: c.ConnectedAt,
+		LastInputAt: c.lastInputAt,
+		// fields below are NOT guarded by Server.controlLocker
+		LastActivityAt: c.LastActivityAt(),
+		BytesSent:      c.BytesSent(),
+		Permissions:    c.user.Permissions,
+	}
+}
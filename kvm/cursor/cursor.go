@@ -0,0 +1,109 @@
+package cursor
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	_ "image/png"
+	"os"
+)
+
+var (
+	EmptyCursor = errors.New("cursor is empty")
+)
+
+// Cursor is the shape drawn by VNC client at its local pointer position
+type Cursor struct {
+	Image image.Image
+	// Hotspot the point of Image which clicks
+	Hotspot image.Point
+}
+
+// FromASCII creates a cursor from ASCII art,
+// `X` is black, `o` is white, and anything else is transparent.
+func FromASCII(lines []string, hotspot image.Point) *Cursor {
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(line))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, len(lines)))
+	for y, line := range lines {
+		for x, c := range []byte(line) {
+			switch c {
+			case 'X':
+				img.Set(x, y, color.RGBA{A: 0xff})
+			case 'o':
+				img.Set(x, y, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	return &Cursor{
+		Image:   img,
+		Hotspot: hotspot,
+	}
+}
+
+// Load reads a PNG file as cursor, transparent pixels are not drawn
+func Load(path string, hotspot image.Point) (*Cursor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if img.Bounds().Empty() {
+		return nil, EmptyCursor
+	}
+
+	// make sure the top-left is (0, 0)
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	for y := 0; y < rgba.Rect.Dy(); y++ {
+		for x := 0; x < rgba.Rect.Dx(); x++ {
+			rgba.Set(x, y, img.At(img.Bounds().Min.X+x, img.Bounds().Min.Y+y))
+		}
+	}
+
+	return &Cursor{
+		Image:   rgba,
+		Hotspot: hotspot,
+	}, nil
+}
+
+var Arrow = FromASCII([]string{
+	"X           ",
+	"XX          ",
+	"XoX         ",
+	"XooX        ",
+	"XoooX       ",
+	"XooooX      ",
+	"XoooooX     ",
+	"XooooooX    ",
+	"XoooooooX   ",
+	"XooooooooX  ",
+	"XoooooooooX ",
+	"XooooooXXXXX",
+	"XoooXooX    ",
+	"XooX XooX   ",
+	"XoX  XooX   ",
+	"XX    XooX  ",
+	"X     XooX  ",
+	"       XooX ",
+	"       XXX  ",
+}, image.Point{})
+
+var Dot = FromASCII([]string{
+	" XXX ",
+	"XoooX",
+	"XoooX",
+	"XoooX",
+	" XXX ",
+}, image.Point{X: 2, Y: 2})
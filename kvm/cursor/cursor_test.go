@@ -0,0 +1,59 @@
+package cursor
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFromASCII(t *testing.T) {
+	c := FromASCII([]string{"Xo", " "}, image.Pt(1, 0))
+
+	if c.Image.Bounds() != image.Rect(0, 0, 2, 2) {
+		t.Fatalf("unexpected bounds: %v", c.Image.Bounds())
+	}
+
+	expected := map[image.Point]color.RGBA{
+		{X: 0, Y: 0}: {A: 0xff},
+		{X: 1, Y: 0}: {R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		{X: 0, Y: 1}: {},
+		{X: 1, Y: 1}: {},
+	}
+	for p, e := range expected {
+		if c := color.RGBAModel.Convert(c.Image.At(p.X, p.Y)); c != e {
+			t.Fatalf("pixel at %v: expected %v, got %v", p, e, c)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := path.Join(t.TempDir(), "cursor.png")
+
+	img := image.NewRGBA(image.Rect(4, 4, 8, 6))
+	img.Set(4, 4, color.RGBA{R: 0xff, A: 0xff})
+
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, img)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(file, image.Pt(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Image.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Fatalf("unexpected bounds: %v", c.Image.Bounds())
+	}
+	if r, _, _, _ := c.Image.At(0, 0).RGBA(); r != 0xffff {
+		t.Fatalf("expected red at (0, 0), got %v", c.Image.At(0, 0))
+	}
+}
@@ -0,0 +1,108 @@
+package kvm
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Fence flags
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#fence
+const (
+	FenceBlockBefore uint32 = 1 << 0
+	FenceBlockAfter  uint32 = 1 << 1
+	FenceSyncNext    uint32 = 1 << 2
+	FenceRequest     uint32 = 1 << 31
+
+	// FenceSupportedFlags messages are handled one by one, so blocking is always satisfied
+	FenceSupportedFlags = FenceBlockBefore | FenceBlockAfter | FenceSyncNext
+
+	MaxFencePayloadSize = 64
+	// FencePingInterval how often to measure the latency of client
+	FencePingInterval = time.Second
+)
+
+// AppendFence
+//
+//	+--------------+--------------+--------------+
+//	| No. of bytes | Type [Value] | Description  |
+//	+--------------+--------------+--------------+
+//	| 1            | U8 [248]     | message-type |
+//	| 3            |              | padding      |
+//	| 4            | U32          | flags        |
+//	| 1            | U8           | length       |
+//	| length       | U8 array     | payload      |
+//	+--------------+--------------+--------------+
+func AppendFence(dst []byte, flags uint32, payload []byte) []byte {
+	dst = append(dst, byte(ServerFence), 0, 0, 0)
+	dst = binary.BigEndian.AppendUint32(dst, flags)
+	dst = append(dst, byte(len(payload)))
+	return append(dst, payload...)
+}
+
+func (s *Server) handleFence(client *Client) error {
+	err := client.Read(client.fence)
+	if err != nil {
+		return err
+	}
+
+	flags := binary.BigEndian.Uint32(client.fence[3:7])
+
+	// the whole payload is read even if it is too long, otherwise the rest is parsed as next message
+	payload := make([]byte, client.fence[7])
+	err = client.Read(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > MaxFencePayloadSize {
+		l.Warn().Printf("Fence payload of client %d is %d bytes, truncated to %d", client.ID, len(payload), MaxFencePayloadSize)
+		payload = payload[:MaxFencePayloadSize]
+	}
+
+	if flags&FenceRequest == 0 {
+		s.handleFenceResponse(client, payload)
+		return nil
+	}
+
+	reply := AppendFence(nil, flags&FenceSupportedFlags, payload)
+
+	if flags&FenceSyncNext != 0 {
+		// reply after the next message is handled
+		client.pendingFence = reply
+		client.pendingFenceDue = false
+		return nil
+	}
+
+	_, err = client.Write(reply)
+	return err
+}
+
+// pingFence sends a fence with current time to measure the latency of client.
+// It is sent after framebuffer updates, so the latency includes the time to receive them.
+func (s *Server) pingFence(client *Client) error {
+	if !client.fenceSupported || time.Since(client.lastPingAt) < FencePingInterval {
+		return nil
+	}
+
+	client.lastPingAt = time.Now()
+
+	payload := binary.BigEndian.AppendUint64(nil, uint64(client.lastPingAt.UnixNano()))
+	_, err := client.Write(AppendFence(nil, FenceRequest|FenceBlockBefore, payload))
+	return err
+}
+
+func (s *Server) handleFenceResponse(client *Client, payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	latency := time.Since(sentAt)
+	if latency < 0 {
+		return
+	}
+
+	client.latency.Store(int64(latency))
+
+	l.Verbose().Println("Fence latency:", latency)
+}
@@ -0,0 +1,66 @@
+package kvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientFence encodes a Fence message from client
+func clientFence(flags uint32, payload []byte) []byte {
+	msg := []byte{byte(Fence), 0, 0, 0}
+	msg = binary.BigEndian.AppendUint32(msg, flags)
+	msg = append(msg, byte(len(payload)))
+	return append(msg, payload...)
+}
+
+func TestHandleFenceOversizedPayload(t *testing.T) {
+	server, conn := net.Pipe()
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	client := NewClient(server, time.Second)
+	s := &Server{}
+
+	go func() {
+		// a response with a payload longer than MaxFencePayloadSize, followed by a request
+		_, _ = conn.Write(clientFence(FenceBlockBefore, bytes.Repeat([]byte{0xff}, 200)))
+		_, _ = conn.Write(clientFence(FenceRequest|FenceBlockBefore, []byte("ok")))
+	}()
+
+	msgType := make([]byte, 1)
+	for i := 0; i < 2; i++ {
+		err := client.Read(msgType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ClientMessageType(msgType[0]) != Fence {
+			t.Fatalf("message %d: expected Fence, got %d", i, msgType[0])
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.handleFence(client)
+		}()
+
+		if i == 1 {
+			reply := make([]byte, 11)
+			_, err = io.ReadFull(conn, reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(reply, AppendFence(nil, FenceBlockBefore, []byte("ok"))) {
+				t.Fatalf("unexpected reply %v", reply)
+			}
+		}
+
+		err = <-done
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package guest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/allape/openkvm/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxTTL the longest lifetime of a token if it is NOT configured
+	DefaultMaxTTL = 24 * time.Hour
+	// SecretSize of the random secret generated when no secret is configured
+	SecretSize = 32
+)
+
+var (
+	InvalidToken = errors.New("invalid share token")
+	TokenExpired = errors.New("share token expired")
+	InvalidTTL   = errors.New("invalid ttl")
+	TokenRevoked = errors.New("share token revoked")
+)
+
+// Grant is the payload of a share token
+type Grant struct {
+	// ID random id of the token, for audit
+	ID string `json:"id"`
+	// Control allows keyboard, mouse and clipboard, otherwise view-only
+	Control   bool      `json:"control,omitempty"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// User the guest user of grant, it has no password
+func (g Grant) User() config.User {
+	permissions := config.Permissions{config.PermissionView}
+	if g.Control {
+		permissions = append(permissions, config.PermissionInput, config.PermissionClipboard)
+	}
+	return config.User{
+		Username:    "guest-" + g.ID,
+		Permissions: permissions,
+	}
+}
+
+// Issuer signs and verifies share tokens with HMAC-SHA256,
+// a token looks like `base64url(json(Grant)).base64url(signature)`.
+type Issuer struct {
+	secret []byte
+	maxTTL time.Duration
+	now    func() time.Time
+
+	revokedLocker sync.Mutex
+	// revoked IDs of grants, with the time after which every token of the ID has expired
+	revoked map[string]time.Time
+}
+
+// Issue returns a token which expires after ttl
+func (i *Issuer) Issue(ttl time.Duration, control bool) (string, Grant, error) {
+	if ttl <= 0 || ttl > i.maxTTL {
+		return "", Grant{}, InvalidTTL
+	}
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", Grant{}, err
+	}
+
+	grant := Grant{
+		ID:        hex.EncodeToString(id),
+		Control:   control,
+		ExpiresAt: i.now().Add(ttl).Truncate(time.Second),
+	}
+
+	payload, err := json.Marshal(grant)
+	if err != nil {
+		return "", Grant{}, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(i.sign(encoded)), grant, nil
+}
+
+// Verify returns the grant of token if it is signed by this issuer and NOT expired
+func (i *Issuer) Verify(token string) (Grant, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Grant{}, InvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, i.sign(encoded)) {
+		return Grant{}, InvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Grant{}, InvalidToken
+	}
+
+	var grant Grant
+	err = json.Unmarshal(payload, &grant)
+	if err != nil || grant.ID == "" {
+		return Grant{}, InvalidToken
+	}
+
+	if !i.now().Before(grant.ExpiresAt) {
+		return grant, TokenExpired
+	}
+
+	if i.Revoked(grant.ID) {
+		return grant, TokenRevoked
+	}
+
+	return grant, nil
+}
+
+// Revoke invalidates tokens of grant id before they expire,
+// revocations are kept in memory, and forgotten on restart.
+func (i *Issuer) Revoke(id string) {
+	i.revokedLocker.Lock()
+	defer i.revokedLocker.Unlock()
+
+	now := i.now()
+	for revokedID, expiresAt := range i.revoked {
+		if !now.Before(expiresAt) {
+			delete(i.revoked, revokedID)
+		}
+	}
+
+	// no token lives longer than maxTTL
+	i.revoked[id] = now.Add(i.maxTTL)
+}
+
+// Revoked tells if grant id is revoked
+func (i *Issuer) Revoked(id string) bool {
+	i.revokedLocker.Lock()
+	defer i.revokedLocker.Unlock()
+
+	_, ok := i.revoked[id]
+	return ok
+}
+
+// MaxTTL the longest lifetime of a token
+func (i *Issuer) MaxTTL() time.Duration {
+	return i.maxTTL
+}
+
+func (i *Issuer) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// New creates an issuer with secret, a random one is generated if secret is empty,
+// tokens are invalidated on restart in that case.
+// maxTTL 0 means DefaultMaxTTL.
+func New(secret string, maxTTL time.Duration) (*Issuer, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, SecretSize)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxTTL
+	}
+
+	return &Issuer{
+		secret:  key,
+		maxTTL:  maxTTL,
+		now:     time.Now,
+		revoked: make(map[string]time.Time),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package guest
+
+import (
+	"errors"
+	"github.com/allape/openkvm/config"
+	"testing"
+	"time"
+)
+
+func TestIssuer(t *testing.T) {
+	now := time.Now()
+
+	issuer, err := New("secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.now = func() time.Time {
+		return now
+	}
+
+	if _, _, err = issuer.Issue(2*time.Hour, false); !errors.Is(err, InvalidTTL) {
+		t.Fatalf("expected %v, got %v", InvalidTTL, err)
+	}
+
+	token, grant, err := issuer.Issue(15*time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.ID != grant.ID || verified.Control || !verified.ExpiresAt.Equal(grant.ExpiresAt) {
+		t.Fatalf("expected %+v, got %+v", grant, verified)
+	}
+	if user := verified.User(); !user.Can(config.PermissionView) || user.Can(config.PermissionInput) {
+		t.Fatalf("expected view-only user, got %+v", user)
+	}
+
+	other, _ := New("other secret", time.Hour)
+	if _, err = other.Verify(token); !errors.Is(err, InvalidToken) {
+		t.Fatalf("token of other secret: expected %v, got %v", InvalidToken, err)
+	}
+
+	// flip a char of the payload
+	tampered := []byte(token)
+	tampered[0] ^= 1
+	if _, err = issuer.Verify(string(tampered)); !errors.Is(err, InvalidToken) {
+		t.Fatalf("tampered token: expected %v, got %v", InvalidToken, err)
+	}
+
+	now = now.Add(15 * time.Minute)
+	if _, err = issuer.Verify(token); !errors.Is(err, TokenExpired) {
+		t.Fatalf("expected %v, got %v", TokenExpired, err)
+	}
+}
+
+func TestIssuerRevoke(t *testing.T) {
+	now := time.Now()
+
+	issuer, err := New("secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.now = func() time.Time {
+		return now
+	}
+
+	token, grant, err := issuer.Issue(time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := issuer.Issue(time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.Revoke(grant.ID)
+
+	if _, err = issuer.Verify(token); !errors.Is(err, TokenRevoked) {
+		t.Fatalf("expected %v, got %v", TokenRevoked, err)
+	}
+	if _, err = issuer.Verify(other); err != nil {
+		t.Fatalf("other token should NOT be revoked, got %v", err)
+	}
+
+	// revocation is pruned after every token of it has expired
+	now = now.Add(time.Hour)
+	issuer.Revoke("another")
+	if issuer.Revoked(grant.ID) || !issuer.Revoked("another") {
+		t.Fatalf("expected only the latest revocation kept, got %v", issuer.revoked)
+	}
+}
@@ -3,18 +3,28 @@ package kvm
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/allape/gogger"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/crypto/des"
+	"github.com/allape/openkvm/helper"
+	"github.com/allape/openkvm/kvm/audit"
 	"github.com/allape/openkvm/kvm/clipboard"
 	"github.com/allape/openkvm/kvm/codec"
+	"github.com/allape/openkvm/kvm/cursor"
+	"github.com/allape/openkvm/kvm/guest"
 	"github.com/allape/openkvm/kvm/keymouse"
+	"github.com/allape/openkvm/kvm/lockout"
 	"github.com/allape/openkvm/kvm/video"
+	"image"
 	"io"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +33,18 @@ var l = gogger.New("kvm")
 const (
 	Version       = "RFB 003.008\n"
 	ChallengeSize = des.BlockSize * 2
+	// MaxCredentialLength of username and password of Plain auth, which are sent before auth
+	MaxCredentialLength = 255
+	// MinDesktopSize and MaxDesktopSize bound width and height requested by SetDesktopSize
+	MinDesktopSize = 64
+	MaxDesktopSize = 8192
+	// MaxClientCutTextLength longer text of ClientCutText is dropped
+	MaxClientCutTextLength = 1 << 20
+	// CloseReasonTimeout how long Close waits to send the reason
+	CloseReasonTimeout = time.Second
+
+	TooManyAuthFailuresReason = "Too many authentication failures"
+	ShareLinkExpiredReason    = "Share link expired"
 )
 
 type SecurityResult [4]byte
@@ -38,6 +60,7 @@ const (
 	None              SecurityType = 1
 	VNCAuthentication SecurityType = 2
 	Plain             SecurityType = 0 // 256
+	VeNCrypt          SecurityType = 19
 )
 
 type ClientMessageType byte
@@ -50,6 +73,9 @@ const (
 	KeyEvent                 ClientMessageType = 4
 	PointerEvent             ClientMessageType = 5
 	ClientCutText            ClientMessageType = 6
+	EnableContinuousUpdates  ClientMessageType = 150
+	Fence                    ClientMessageType = 248
+	SetDesktopSize           ClientMessageType = 251
 )
 
 var (
@@ -58,23 +84,32 @@ var (
 	HandshakeFailed     = errors.New("handshake failed")
 	AuthFailed          = errors.New("auth failed")
 	UnsupportedAuthType = errors.New("unsupported auth type")
+	TooManyAuthFailures = errors.New("too many authentication failures")
+
+	NoCodecAvailable = errors.New("no video codec is available")
+
+	ExclusiveAccessRefused = errors.New("exclusive access is refused, other clients are connected")
+	ClientNotFound         = errors.New("client not found")
+	ClientDisconnected     = errors.New("client is disconnected by server")
+	PermissionDenied       = errors.New("permission denied")
 
 	KeyboardNotAvailable = errors.New("keyboard driver is not available")
 	MouseNotAvailable    = errors.New("mouse driver is not available")
 )
 
-type PixelFormat struct {
-	BitsPerPixel uint8
-	Depth        uint8
-	BigEndian    uint8
-	TrueColor    uint8
-	RedMax       uint16
-	GreenMax     uint16
-	BlueMax      uint16
-	RedShift     uint8
-	GreenShift   uint8
-	BlueShift    uint8
-}
+type ServerMessageType byte
+
+const (
+	FramebufferUpdate   ServerMessageType = 0
+	SetColourMapEntries ServerMessageType = 1
+	Bell                ServerMessageType = 2
+	ServerCutText       ServerMessageType = 3
+
+	EndOfContinuousUpdates ServerMessageType = 150
+	ServerFence            ServerMessageType = 248
+)
+
+type PixelFormat = codec.PixelFormat
 
 type ServerInit struct {
 	Name        string
@@ -85,19 +120,41 @@ type ServerInit struct {
 
 type Options struct {
 	Config config.Config
+	// Cursor drawn by VNC client, nil to disable
+	Cursor *cursor.Cursor
+	// TLSConfig enables VeNCrypt security type, nil to disable
+	TLSConfig *tls.Config
+	// Audit logs sessions and actions, nil to disable
+	Audit audit.Logger
 }
 
 type Server struct {
-	Keyboard   keymouse.Driver
-	Video      video.Driver
-	Mouse      keymouse.Driver
-	VideoCodec codec.Codec
-	Clipboard  clipboard.Driver
+	Keyboard    keymouse.Driver
+	Video       video.Driver
+	Mouse       keymouse.Driver
+	VideoCodecs []codec.Factory
+	Clipboard   clipboard.Driver
 
 	Options Options
 
 	serverInitBytes []byte
+	frameSize       image.Point
 	locker          sync.Locker
+	// resizeLocker serializes SetDesktopSize, locker is NOT held while video restarts
+	resizeLocker sync.Locker
+
+	clients       map[*Client]struct{}
+	clientsLocker sync.Locker
+	lastClientID  atomic.Uint64
+
+	// controller is the only client whose input is sent to devices
+	controller    *Client
+	controlLocker sync.Locker
+
+	frames *video.Broadcaster
+
+	// Lockout counts auth failures per IP, it is shared with HTTP auth
+	Lockout *lockout.Guard
 }
 
 func (s *Server) handshake(client *Client) (ok bool, err error) {
@@ -116,28 +173,62 @@ func (s *Server) handshake(client *Client) (ok bool, err error) {
 		return false, client.Close("Unsupported protocol version")
 	}
 
-	if s.Options.Config.VNC.Password == "" {
+	if wait := s.Lockout.Blocked(client.RemoteAddr); wait > 0 {
+		l.Warn().Printf("Refuse %s, blocked for %s", client.RemoteAddr, wait.Round(time.Second))
+		// number-of-security-types 0 is followed by the reason
+		_, _ = client.Write([]byte{0})
+		_ = client.Close(TooManyAuthFailuresReason)
+
+		event := client.auditEvent(audit.AuthFail)
+		event.Reason = TooManyAuthFailuresReason
+		s.Options.Audit.Log(event)
+
+		return false, TooManyAuthFailures
+	}
+
+	users := s.Options.Config.VNC.AllUsers()
+	tlsOnly := s.Options.TLSConfig != nil && s.Options.Config.VNC.TLSOnly
+
+	client.securityTypes = client.securityTypes[:0]
+
+	if client.Guest != nil {
+		if !time.Now().Before(client.Guest.ExpiresAt) {
+			_, _ = client.Write([]byte{0})
+			_ = client.Close(ShareLinkExpiredReason)
+			return false, guest.TokenExpired
+		}
+		// the share token is verified by the transport already
+		l.Info().Println("Use None auth type for guest", client.Guest.ID)
+		client.securityTypes = append(client.securityTypes, None)
+	} else if s.Options.TLSConfig != nil {
+		l.Info().Println("Use VeNCrypt security type")
+		client.securityTypes = append(client.securityTypes, VeNCrypt)
+	}
+
+	if client.Guest != nil || tlsOnly {
+		// no cleartext auth
+	} else if len(users) == 0 {
 		for i := 0; i < 9; i++ {
 			l.Warn().Println("No password set, use None auth type")
 		}
-		_, err = client.Write([]byte{1, byte(None)})
-		if err != nil {
-			return false, err
+		client.securityTypes = append(client.securityTypes, None)
+	} else {
+		plain, vnc := s.authMethods()
+		if plain {
+			l.Info().Println("Use Tight security type with username")
+			client.securityTypes = append(client.securityTypes, Plain)
 		}
-		return true, nil
-	}
-
-	if s.Options.Config.VNC.Username != "" {
-		l.Info().Printf("Use Tight security type with username: %s; And use std VNC as fallback auth", s.Options.Config.VNC.Username)
-		_, err = client.Write([]byte{2, byte(Plain), byte(VNCAuthentication)})
-		if err != nil {
-			return false, err
+		if vnc {
+			l.Info().Println("Use std VNC auth type")
+			client.securityTypes = append(client.securityTypes, VNCAuthentication)
 		}
-		return true, nil
 	}
 
-	l.Info().Println("Use std VNC auth type")
-	_, err = client.Write([]byte{1, byte(VNCAuthentication)})
+	types := []byte{byte(len(client.securityTypes))}
+	for _, t := range client.securityTypes {
+		types = append(types, byte(t))
+	}
+	_, err = client.Write(types)
 	if err != nil {
 		return false, err
 	}
@@ -145,6 +236,44 @@ func (s *Server) handshake(client *Client) (ok bool, err error) {
 	return true, nil
 }
 
+// authMethods reports whether any user can log in with username and password, or with std VNC auth
+func (s *Server) authMethods() (plain, vnc bool) {
+	for _, user := range s.Options.Config.VNC.AllUsers() {
+		if user.Username != "" || user.PasswordHash != "" {
+			plain = true
+		}
+		if user.VNCPassword() != "" {
+			vnc = true
+		}
+	}
+	return plain, vnc
+}
+
+// sendChallenge sends a random challenge of VNC auth
+func (s *Server) sendChallenge(client *Client) error {
+	if len(s.Options.Config.VNC.AllUsers()) == 0 {
+		_ = client.Close(InternalServerError.Error())
+		return UnsupportedAuthType
+	}
+
+	client.challenge = make([]byte, ChallengeSize)
+	n, err := rand.Read(client.challenge)
+	if err != nil {
+		_ = client.Close(InternalServerError.Error())
+		return err
+	} else if n != ChallengeSize {
+		_ = client.Close(InternalServerError.Error())
+		return io.ErrShortWrite
+	}
+
+	_, err = client.Write(client.challenge)
+	if err != nil {
+		return client.Close(InternalServerError.Error())
+	}
+
+	return nil
+}
+
 func (s *Server) challenge(client *Client) (err error) {
 	st := make([]byte, 1)
 
@@ -155,7 +284,11 @@ func (s *Server) challenge(client *Client) (err error) {
 
 	client.respSecurityType = SecurityType(st[0])
 
-	switch SecurityType(st[0]) {
+	if !slices.Contains(client.securityTypes, client.respSecurityType) {
+		return client.Close("Unsupported auth type")
+	}
+
+	switch client.respSecurityType {
 	case None:
 		//err = client.Close("Unsupported auth type")
 		//if err != nil {
@@ -164,37 +297,45 @@ func (s *Server) challenge(client *Client) (err error) {
 		//return UnsupportedAuthType
 		return nil
 	case VNCAuthentication:
-		if s.Options.Config.VNC.Password == "" {
-			_ = client.Close(InternalServerError.Error())
-			return UnsupportedAuthType
-		}
-
-		client.challenge = make([]byte, ChallengeSize)
-		n, err := rand.Read(client.challenge)
-		if err != nil {
-			_ = client.Close(InternalServerError.Error())
-			return err
-		} else if n != ChallengeSize {
-			_ = client.Close(InternalServerError.Error())
-			return io.ErrShortWrite
-		}
-
-		_, err = client.Write(client.challenge)
-		if err != nil {
-			return client.Close(InternalServerError.Error())
-		}
-
-		return nil
+		return s.sendChallenge(client)
 	case Plain:
 		return nil
+	case VeNCrypt:
+		return s.handleVeNCrypt(client)
 	default:
 		return client.Close("Unsupported auth type")
 	}
 }
 
+// authFailed sends the failure with reason, and counts the failure for brute-force protection
+func (s *Server) authFailed(client *Client, username, reason string) error {
+	event := client.auditEvent(audit.AuthFail)
+	event.Username = username
+	event.Reason = reason
+	s.Options.Audit.Log(event)
+
+	if s.Lockout.Fail(client.RemoteAddr) {
+		event.Type = audit.Lockout
+		event.Reason = TooManyAuthFailuresReason
+		s.Options.Audit.Log(event)
+
+		reason = TooManyAuthFailuresReason
+	}
+
+	_, _ = client.Write(SecurityResultFail[:])
+	return client.Close(reason)
+}
+
 func (s *Server) auth(client *Client) (ok bool, err error) {
 	switch client.respSecurityType {
 	case None:
+		if client.Guest != nil {
+			client.user = client.Guest.User()
+			client.Username = client.user.Username
+		} else {
+			// everyone is admin without users
+			client.user = config.User{Permissions: config.Permissions{config.PermissionAdmin}}
+		}
 		_, err = client.Write(SecurityResultOK[:])
 		if err != nil {
 			return false, err
@@ -205,14 +346,6 @@ func (s *Server) auth(client *Client) (ok bool, err error) {
 			return false, client.Close(InternalServerError.Error())
 		}
 
-		d := des.New([]byte(s.Options.Config.VNC.Password))
-		expectedChallenged := make([]byte, ChallengeSize)
-		err = d.Encrypt(expectedChallenged, client.challenge)
-		if err != nil {
-			_ = client.Close(InternalServerError.Error())
-			return false, err
-		}
-
 		clientChallenged := make([]byte, ChallengeSize)
 		err = client.Read(clientChallenged)
 		if err != nil {
@@ -220,9 +353,34 @@ func (s *Server) auth(client *Client) (ok bool, err error) {
 			return false, err
 		}
 
-		if bytes.Compare(expectedChallenged, clientChallenged) != 0 {
+		// VNC auth has no username, the first user whose password matches is used
+		matched := false
+		expectedChallenged := make([]byte, ChallengeSize)
+		for _, user := range s.Options.Config.VNC.AllUsers() {
+			if user.VNCPassword() == "" {
+				continue
+			}
+			d := des.New([]byte(user.VNCPassword()))
+			err = d.Encrypt(expectedChallenged, client.challenge)
+			if err != nil {
+				_ = client.Close(InternalServerError.Error())
+				return false, err
+			}
+			if bytes.Equal(expectedChallenged, clientChallenged) {
+				client.Username = user.Username
+				client.user = user
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false, s.authFailed(client, "", "Password is incorrect")
+		}
+
+		if !client.Can(config.PermissionView) {
 			_, _ = client.Write(SecurityResultFail[:])
-			return false, client.Close("Password is incorrect")
+			return false, client.Close("Permission denied")
 		}
 
 		_, err = client.Write(SecurityResultOK[:])
@@ -242,6 +400,9 @@ func (s *Server) auth(client *Client) (ok bool, err error) {
 
 		lengthOfUsername := binary.BigEndian.Uint32(lengthOfUsernameAndPassword[:4])
 		lengthOfPassword := binary.BigEndian.Uint32(lengthOfUsernameAndPassword[4:])
+		if lengthOfUsername > MaxCredentialLength || lengthOfPassword > MaxCredentialLength {
+			return false, s.authFailed(client, "", "Username or password is too long")
+		}
 
 		usernameAndPassword := make([]byte, lengthOfUsername+lengthOfPassword)
 		err = client.Read(usernameAndPassword)
@@ -253,9 +414,20 @@ func (s *Server) auth(client *Client) (ok bool, err error) {
 		username := usernameAndPassword[:lengthOfUsername]
 		password := usernameAndPassword[lengthOfUsername:]
 
-		if string(username) != s.Options.Config.VNC.Username || string(password) != s.Options.Config.VNC.Password {
+		users := s.Options.Config.VNC.AllUsers()
+		i := slices.IndexFunc(users, func(u config.User) bool {
+			return u.Username == string(username) && u.VerifyPassword(string(password))
+		})
+		if i == -1 {
+			return false, s.authFailed(client, string(username), "Username or password is incorrect")
+		}
+
+		client.Username = string(username)
+		client.user = users[i]
+
+		if !client.Can(config.PermissionView) {
 			_, _ = client.Write(SecurityResultFail[:])
-			return false, client.Close("Username or password is incorrect")
+			return false, client.Close("Permission denied")
 		}
 
 		_, err = client.Write(SecurityResultOK[:])
@@ -278,13 +450,26 @@ func (s *Server) init(client *Client) (err error) {
 		return err
 	}
 
-	// TODO share flag
+	err = s.join(client, shareFlag[0] != 0)
+	if err != nil {
+		s.disconnect(client, err.Error())
+		return err
+	}
 
+	s.locker.Lock()
+	si, err := s.GetServerInit()
+	if err != nil {
+		s.locker.Unlock()
+		return err
+	}
 	msg, err := s.GetServerInitBytes()
+	s.locker.Unlock()
 	if err != nil {
 		return err
 	}
 
+	client.size = image.Point{X: int(si.Width), Y: int(si.Height)}
+
 	_, err = client.Write(msg)
 	if err != nil {
 		return err
@@ -294,38 +479,378 @@ func (s *Server) init(client *Client) (err error) {
 }
 
 func (s *Server) handleFramebufferUpdateRequest(client *Client) error {
+	err := client.Read(client.framebufferUpdateRequest)
+	if err != nil {
+		return err
+	}
+
+	incremental := client.framebufferUpdateRequest[0] != 0
+	region := parseRect(client.framebufferUpdateRequest[1:9])
+
+	client.updateLocker.Lock()
+	if client.updateRequested {
+		// merge with the pending one
+		incremental = incremental && client.updateIncremental
+		region = region.Union(client.updateRegion)
+	}
+	client.updateRequested = true
+	client.updateIncremental = incremental
+	client.updateRegion = region
+	client.updateLocker.Unlock()
+
+	client.wake()
+
+	return nil
+}
+
+// sendFramebufferUpdate sends the changes of frame in region,
+// an update without any rect is skipped if skipEmpty is true, and sent is false.
+// client.updateLocker should be held by caller.
+func (s *Server) sendFramebufferUpdate(client *Client, frame config.Frame, incremental bool, region image.Rectangle, skipEmpty bool) (sent bool, err error) {
+	if frame == nil {
+		// video is not ready yet
+		return false, nil
+	}
+
+	if skipEmpty && incremental && frame == client.previewFrame && !s.hasPendingPseudoRects(client) {
+		return false, nil
+	}
+
+	if client.codec == nil {
+		selected := s.selectCodec(client.encodings)
+		if selected == nil {
+			return false, NoCodecAvailable
+		}
+		client.useCodec(selected)
+	}
+
+	resizeRect, resizeCount := s.checkDesktopSize(client, frame.Bounds().Size())
+	if resizeCount > 0 {
+		// DesktopSize should be the last rect, pixels in new size are sent by the next update
+		cursorRect, cursorCount := s.checkCursor(client)
+		buffer := codec.AppendRects(nil, cursorRect, cursorCount)
+		buffer = codec.AppendRects(buffer, resizeRect, resizeCount)
+
+		_, err = client.Write(buffer)
+		if err != nil {
+			return false, err
+		}
+
+		client.wake()
+
+		return true, s.pingFence(client)
+	}
+
+	if frame.Bounds().Size() != client.size {
+		// client does NOT support DesktopSize, it sees the top-left part of frame in its original size
+		frame = helper.CropFrame(frame, client.size)
+	}
+
+	previewFrame := client.previewFrame
+	if !incremental {
+		// client may lost its framebuffer, send everything in region again
+		previewFrame = nil
+	}
+
+	var copyRects []config.CopyRect
+	if !s.Options.Config.Video.DisableCopyRect && slices.Contains(client.encodings, codec.CopyRect) {
+		copyRects = helper.CalcCopyRects(previewFrame, frame, s.Options.Config.Video.SliceCount)
+		if len(copyRects) > 0 {
+			// what VNC client will have after copying
+			previewFrame = helper.ApplyCopyRects(previewFrame, copyRects)
+		}
+	}
+
+	// only the slices touched by the requested region are sent,
+	// by pretending that client already has next frame outside the region
+	nextPreviewFrame := frame
+	region = helper.AlignRectToSlices(region, frame.Bounds(), s.Options.Config.Video.SliceCount)
+	if region != frame.Bounds() {
+		// what client keeps outside the region
+		kept := previewFrame
+		if kept == nil {
+			kept = client.previewFrame
+		}
+		if kept == nil {
+			// unknown, pixels outside the region are sent by the following incremental updates
+			kept = image.NewRGBA(frame.Bounds())
+		}
+		nextPreviewFrame = helper.MergeFrames(kept, frame, region)
+
+		if previewFrame == nil {
+			previewFrame = helper.InvertRect(frame, region)
+		} else {
+			previewFrame = helper.MergeFrames(frame, previewFrame, region)
+		}
+	}
+
+	buffer, err := client.codec.FramebufferUpdate(previewFrame, frame, client.pixelFormat)
+	if err != nil {
+		return false, err
+	}
+
+	if len(copyRects) > 0 {
+		var rects []byte
+		for _, c := range copyRects {
+			rects = codec.AppendCopyRect(rects, c)
+		}
+		buffer = codec.PrependRects(buffer, rects, len(copyRects))
+	}
+
+	cursorRect, cursorCount := s.checkCursor(client)
+	buffer = codec.PrependRects(buffer, cursorRect, cursorCount)
+
+	client.previewFrame = nextPreviewFrame
+
+	if skipEmpty && len(buffer) >= 4 && buffer[2] == 0 && buffer[3] == 0 {
+		return false, nil
+	}
+
+	_, err = client.Write(buffer)
+	if err != nil {
+		return false, err
+	}
+
+	return true, s.pingFence(client)
+}
+
+// hasPendingPseudoRects returns true if pseudo-rects should be sent even if the frame is not changed
+func (s *Server) hasPendingPseudoRects(client *Client) bool {
+	return client.pendingDesktopSize || (s.Options.Cursor != nil && !client.cursorSent)
+}
+
+// parseRect parses x-position, y-position, width and height in U16
+func parseRect(bs []byte) image.Rectangle {
+	x := int(binary.BigEndian.Uint16(bs[0:2]))
+	y := int(binary.BigEndian.Uint16(bs[2:4]))
+	return image.Rect(
+		x, y,
+		x+int(binary.BigEndian.Uint16(bs[4:6])),
+		y+int(binary.BigEndian.Uint16(bs[6:8])),
+	)
+}
+
+// checkDesktopSize compares the size of frame with what the client has,
+// and returns a DesktopSize or ExtendedDesktopSize pseudo-rect if it changed.
+func (s *Server) checkDesktopSize(client *Client, size image.Point) (rect []byte, count int) {
 	s.locker.Lock()
-	defer s.locker.Unlock()
+	if size != s.frameSize {
+		if s.frameSize != (image.Point{}) {
+			l.Info().Printf("Frame size changed from %v to %v", s.frameSize, size)
+		}
+		s.frameSize = size
+		s.serverInitBytes = nil
+	}
+	s.locker.Unlock()
+
+	extended := slices.Contains(client.encodings, codec.ExtendedDesktopSize)
+	if !extended && !slices.Contains(client.encodings, codec.DesktopSize) {
+		// client keeps the size of ServerInit, frames are cropped to it
+		client.pendingDesktopSize = false
+		if size != client.size && !client.cropped {
+			l.Warn().Printf("VNC client does not support DesktopSize, frame size %v is cropped to %v", size, client.size)
+		}
+		client.cropped = size != client.size
+		return nil, 0
+	}
+
+	if size == client.size && !client.pendingDesktopSize {
+		return nil, 0
+	}
 
-	_ = client.Read(client.framebufferUpdateRequest)
+	reason := client.desktopSizeReason
 
-	frame, err := s.Video.NextFrame()
+	client.pendingDesktopSize = false
+	client.desktopSizeReason = codec.DesktopSizeChangedByServer
+	client.size = size
+	client.previewFrame = nil
+
+	if extended {
+		return codec.AppendExtendedDesktopSize(nil, reason, codec.DesktopSizeNoError, size.X, size.Y), 1
+	}
+	return codec.AppendDesktopSize(nil, size.X, size.Y), 1
+}
+
+// checkCursor returns a Cursor or XCursor pseudo-rect if it has not been sent to client yet
+func (s *Server) checkCursor(client *Client) (rect []byte, count int) {
+	c := s.Options.Cursor
+	if c == nil || client.cursorSent {
+		return nil, 0
+	}
+
+	client.cursorSent = true
+
+	switch {
+	case slices.Contains(client.encodings, codec.Cursor):
+		return codec.AppendCursor(nil, c.Image, c.Hotspot, client.pixelFormat), 1
+	case slices.Contains(client.encodings, codec.XCursor):
+		return codec.AppendXCursor(nil, c.Image, c.Hotspot), 1
+	default:
+		return nil, 0
+	}
+}
+
+func (s *Server) handleSetDesktopSize(client *Client) error {
+	err := client.Read(client.setDesktopSize)
 	if err != nil {
 		return err
 	}
 
-	buffer, err := s.VideoCodec.FramebufferUpdate(client.previewFrame, frame)
+	size := config.Size{
+		X: int(binary.BigEndian.Uint16(client.setDesktopSize[1:3])),
+		Y: int(binary.BigEndian.Uint16(client.setDesktopSize[3:5])),
+	}
+	numberOfScreens := int(client.setDesktopSize[5])
+
+	// only one screen is supported, the layout is ignored
+	err = client.Read(make([]byte, numberOfScreens*16))
 	if err != nil {
 		return err
 	}
 
-	client.previewFrame = frame
+	status := codec.DesktopSizeInvalidScreenData
+	if numberOfScreens > 0 {
+		status = s.setDesktopSize(size)
+	}
+
+	l.Info().Printf("SetDesktopSize: %dx%d, status: %d", size.X, size.Y, status)
 
+	defer client.wake()
+
+	client.updateLocker.Lock()
+	defer client.updateLocker.Unlock()
+
+	if status == codec.DesktopSizeNoError {
+		// reply with the next frame in new size
+		client.pendingDesktopSize = true
+		client.desktopSizeReason = codec.DesktopSizeChangedByClient
+		return nil
+	}
+
+	buffer := codec.AppendFramebufferUpdateHeader(nil, 1)
+	buffer = codec.AppendExtendedDesktopSize(buffer, codec.DesktopSizeChangedByClient, status, client.size.X, client.size.Y)
 	_, err = client.Write(buffer)
+	return err
+}
+
+// setDesktopSize changes the size of video, and returns the status of ExtendedDesktopSize
+func (s *Server) setDesktopSize(size config.Size) int {
+	resizer, ok := s.Video.(video.Resizer)
+	if !ok {
+		return codec.DesktopSizeProhibited
+	}
+
+	// frames are split into slice_count x slice_count rects
+	sc := max(int(s.Options.Config.Video.SliceCount), 1)
+	if size.X < MinDesktopSize || size.Y < MinDesktopSize ||
+		size.X > MaxDesktopSize || size.Y > MaxDesktopSize ||
+		size.X%sc != 0 || size.Y%sc != 0 {
+		l.Warn().Printf("Requested desktop size %dx%d is out of bounds or NOT divisible by slice count %d", size.X, size.Y, sc)
+		return codec.DesktopSizeInvalidScreenData
+	}
+
+	if resolutions := s.Options.Config.Video.Resolutions; len(resolutions) > 0 {
+		allowed := false
+		for _, resolution := range resolutions {
+			rs, err := resolution.Size()
+			if err != nil {
+				l.Warn().Println(err)
+				continue
+			}
+			if rs == size {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return codec.DesktopSizeInvalidScreenData
+		}
+	}
+
+	s.resizeLocker.Lock()
+	defer s.resizeLocker.Unlock()
+
+	err := resizer.SetSize(size)
+	switch {
+	case err == nil:
+		return codec.DesktopSizeNoError
+	case errors.Is(err, video.ResizeNotSupported):
+		return codec.DesktopSizeProhibited
+	case errors.Is(err, video.InvalidSize):
+		return codec.DesktopSizeInvalidScreenData
+	default:
+		l.Error().Println("Failed to set video size:", err)
+		return codec.DesktopSizeOutOfResources
+	}
+}
+
+func (s *Server) handleSetPixelFormat(client *Client) error {
+	// 0000 0000 2018 0001 00ff 00ff 00ff 1008 0000 0000
+	err := client.Read(client.setPixelFormat)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	pf, err := codec.ParsePixelFormat(client.setPixelFormat[3:])
+	if err != nil {
+		return err
+	}
+
+	l.Debug().Printf("SetPixelFormat: %+v\n", pf)
+
+	defer client.wake()
+
+	client.updateLocker.Lock()
+	defer client.updateLocker.Unlock()
+
+	client.pixelFormat = pf
+	// redraw the whole screen in the new pixel format
+	client.previewFrame = nil
+	client.cursorSent = false
+
+	if pf.IsTrueColor() {
+		return nil
+	}
+
+	return s.sendColourMapEntries(client)
+}
+
+func (s *Server) sendColourMapEntries(client *Client) error {
+	//  +--------------+--------------+------------------+
+	// | No. of bytes | Type [Value] | Description      |
+	// +--------------+--------------+------------------+
+	// | 1            | U8 [1]       | message-type     |
+	// | 1            |              | padding          |
+	// | 2            | U16          | first-color      |
+	// | 2            | U16          | number-of-colors |
+	// +--------------+--------------+------------------+
+	// followed by number-of-colors of U16 red, U16 green and U16 blue
+	count := len(codec.ColourMap)
+	msg := []byte{
+		byte(SetColourMapEntries),
+		0,    // padding
+		0, 0, // first-color
+		byte(count >> 8), byte(count), // number-of-colors
+	}
+	for _, c := range codec.ColourMap {
+		msg = append(msg,
+			c.R, c.R,
+			c.G, c.G,
+			c.B, c.B,
+		)
+	}
+
+	_, err := client.Write(msg)
+	return err
 }
 
 func (s *Server) handleEncoding(client *Client) error {
-	err := client.Read(client.encodings)
+	err := client.Read(client.setEncodings)
 	if err != nil {
 		return err
 	}
 
-	number := binary.BigEndian.Uint16(client.encodings[1:3])
+	number := binary.BigEndian.Uint16(client.setEncodings[1:3])
 
 	encodings := make([]byte, number*4)
 	err = client.Read(encodings)
@@ -333,6 +858,93 @@ func (s *Server) handleEncoding(client *Client) error {
 		return err
 	}
 
+	parsed := make([]codec.Encoding, number)
+	for i := range parsed {
+		parsed[i] = codec.Encoding(binary.BigEndian.Uint32(encodings[i*4:]))
+	}
+
+	l.Debug().Println("SetEncodings:", parsed)
+
+	defer client.wake()
+
+	client.updateLocker.Lock()
+	defer client.updateLocker.Unlock()
+
+	client.encodings = parsed
+
+	// client may start to support cursor
+	client.cursorSent = false
+
+	if !client.continuousUpdates && slices.Contains(client.encodings, codec.ContinuousUpdates) {
+		client.continuousUpdates = true
+		// tell client that ContinuousUpdates is supported
+		err = s.sendEndOfContinuousUpdates(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !client.fenceSupported && slices.Contains(client.encodings, codec.Fence) {
+		client.fenceSupported = true
+		// tell client that Fence is supported
+		err = s.pingFence(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !client.extendedDesktopSize && slices.Contains(client.encodings, codec.ExtendedDesktopSize) {
+		client.extendedDesktopSize = true
+		client.pendingDesktopSize = true
+	}
+
+	selected := s.selectCodec(client.encodings)
+	if selected == nil {
+		return NoCodecAvailable
+	}
+
+	if client.codec == nil || client.codec.Encoding() != selected.Encoding {
+		l.Info().Println("Use encoding:", selected.Encoding)
+		// redraw the whole screen with the new codec
+		client.previewFrame = nil
+	}
+
+	client.useCodec(selected)
+
+	return nil
+}
+
+// selectCodec picks the first codec in the order of encodings requested by client,
+// or in the order of server if encodings are configured.
+// Raw is the fallback if none of them is supported by server.
+func (s *Server) selectCodec(encodings []codec.Encoding) *codec.Factory {
+	if len(s.Options.Config.Video.Encodings) > 0 {
+		for i := range s.VideoCodecs {
+			if slices.Contains(encodings, s.VideoCodecs[i].Encoding) {
+				return &s.VideoCodecs[i]
+			}
+		}
+	}
+
+	for _, encoding := range encodings {
+		for i := range s.VideoCodecs {
+			if s.VideoCodecs[i].Encoding == encoding {
+				return &s.VideoCodecs[i]
+			}
+		}
+	}
+
+	for i := range s.VideoCodecs {
+		if s.VideoCodecs[i].Encoding == codec.Raw {
+			return &s.VideoCodecs[i]
+		}
+	}
+
+	if len(s.VideoCodecs) > 0 {
+		l.Warn().Println("Raw encoding is not available, use", s.VideoCodecs[0].Encoding, "as fallback")
+		return &s.VideoCodecs[0]
+	}
+
 	return nil
 }
 
@@ -346,14 +958,28 @@ func (s *Server) handleKeyEvent(client *Client) error {
 		return KeyboardNotAvailable
 	}
 
-	copy(client.fullKeyEvent[1:], client.keyEvent)
-
-	err = s.Keyboard.SendKeyEvent(client.fullKeyEvent)
-	if err != nil {
-		return err
+	if !client.Can(config.PermissionInput) {
+		l.Verbose().Printf("Drop KeyEvent of client %d without input permission", client.ID)
+		return nil
 	}
 
-	return err
+	return s.withControl(client, func() error {
+		copy(client.fullKeyEvent[1:], client.keyEvent)
+
+		err := s.Keyboard.SendKeyEvent(client.fullKeyEvent)
+		if err != nil {
+			return err
+		}
+
+		key := binary.BigEndian.Uint32(client.keyEvent[3:7])
+		if client.keyEvent[0] != 0 {
+			client.pressedKeys[key] = struct{}{}
+		} else {
+			delete(client.pressedKeys, key)
+		}
+
+		return nil
+	})
 }
 
 func (s *Server) handlePointerEvent(client *Client) error {
@@ -366,6 +992,17 @@ func (s *Server) handlePointerEvent(client *Client) error {
 		return MouseNotAvailable
 	}
 
+	if !client.Can(config.PermissionInput) {
+		l.Verbose().Printf("Drop PointerEvent of client %d without input permission", client.ID)
+		return nil
+	}
+
+	return s.withControl(client, func() error {
+		return s.sendPointerEvent(client)
+	})
+}
+
+func (s *Server) sendPointerEvent(client *Client) error {
 	copy(client.fullPointerEvent[1:], client.pointerEvent)
 
 	//  +--------------+--------------+--------------+
@@ -387,11 +1024,13 @@ func (s *Server) handlePointerEvent(client *Client) error {
 
 	l.Verbose().Printf("Rescale PointerEvent from (%d, %d) to (%d, %d)\n", oldX, oldY, x, y)
 
-	err = s.Mouse.SendPointerEvent(pointerEvent)
+	err := s.Mouse.SendPointerEvent(pointerEvent)
 	if err != nil {
 		return err
 	}
 
+	client.lastPointerEvent = append(client.lastPointerEvent[:0], pointerEvent...)
+
 	return nil
 }
 
@@ -403,6 +1042,15 @@ func (s *Server) handleClientCut(client *Client) error {
 
 	length := binary.BigEndian.Uint32(client.clientCut[3:])
 
+	// do NOT allocate text which is going to be dropped, length is up to 4 GiB
+	if !client.Can(config.PermissionClipboard) {
+		l.Verbose().Printf("Drop ClientCutText of client %d without clipboard permission", client.ID)
+		return client.discard(int64(length))
+	} else if length > MaxClientCutTextLength {
+		l.Warn().Printf("Drop ClientCutText of client %d, %d bytes is longer than %d", client.ID, length, MaxClientCutTextLength)
+		return client.discard(int64(length))
+	}
+
 	text := make([]byte, length)
 	err = client.Read(text)
 	if err != nil {
@@ -411,18 +1059,27 @@ func (s *Server) handleClientCut(client *Client) error {
 
 	l.Debug().Println("ClientCutText:", string(text))
 
-	n, err := s.Clipboard.Write(text)
-	if err != nil {
-		return err
-	} else if n != int(length) {
-		//return io.ErrShortWrite
-		l.Warn().Printf("ClientCutText: short write, expected %d, got %d\n", length, n)
-	}
+	return s.withControl(client, func() error {
+		n, err := s.Clipboard.Write(text)
+		if err != nil {
+			return err
+		} else if n != int(length) {
+			//return io.ErrShortWrite
+			l.Warn().Printf("ClientCutText: short write, expected %d, got %d\n", length, n)
+		}
 
-	return nil
+		event := client.auditEvent(audit.Clipboard)
+		event.Length = len(text)
+		if s.Options.Config.Audit.ClipboardText {
+			event.Text = string(text)
+		}
+		s.Options.Audit.Log(event)
+
+		return nil
+	})
 }
 
-func (s *Server) HandleClient(client *Client) error {
+func (s *Server) HandleClient(client *Client) (err error) {
 	ok, err := s.handshake(client)
 	if err != nil {
 		return err
@@ -442,23 +1099,70 @@ func (s *Server) HandleClient(client *Client) error {
 		return AuthFailed
 	}
 
+	s.Lockout.Succeed(client.RemoteAddr)
+
 	err = s.init(client)
+	defer s.leave(client)
 	if err != nil {
 		return err
 	}
 
+	s.Options.Audit.Log(client.auditEvent(audit.Connect))
+	defer func() {
+		event := client.auditEvent(audit.Disconnect)
+		if reason, ok := client.disconnectReason.Load().(string); ok {
+			event.Reason = reason
+		} else if err != nil {
+			event.Reason = err.Error()
+		}
+		event.Duration = time.Since(client.ConnectedAt).Milliseconds()
+		event.BytesSent = client.BytesSent()
+		s.Options.Audit.Log(event)
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.sender(client, stop)
+	go s.watchdog(client, stop)
+
 	msgType := make([]byte, 1)
 	for {
-		err = client.Read(msgType)
+		if client.pendingFence != nil && client.pendingFenceDue {
+			_, err = client.Write(client.pendingFence)
+			if err != nil {
+				return err
+			}
+			client.pendingFence = nil
+		}
+
+		if client.continuousUpdatesEnabled {
+			// client may have nothing to say while updates are pushed
+			err = client.ReadWithTimeout(msgType, 0)
+		} else {
+			err = client.Read(msgType)
+		}
 		if err != nil {
+			if reason, ok := client.disconnectReason.Load().(string); ok {
+				return fmt.Errorf("%w: %s", ClientDisconnected, reason)
+			}
 			return err
 		}
 
+		// SyncNext fence is replied after this message
+		client.pendingFenceDue = client.pendingFence != nil
+
+		switch ClientMessageType(msgType[0]) {
+		case KeyEvent, PointerEvent, ClientCutText:
+			client.lastActivityAt.Store(time.Now().UnixNano())
+		}
+
 		switch ClientMessageType(msgType[0]) {
 		case SetPixelFormat:
-			// 0000 0000 2018 0001 00ff 00ff 00ff 1008 0000 0000
-			_ = client.Read(client.pixelFormat)
-			continue
+			err = s.handleSetPixelFormat(client)
+			if err != nil {
+				l.Warn().Println("SetPixelFormat error:", err)
+				continue
+			}
 		case Placeholder:
 			continue
 		case SetEncodings:
@@ -491,6 +1195,24 @@ func (s *Server) HandleClient(client *Client) error {
 				l.Warn().Println("ClientCutText error:", err)
 				continue
 			}
+		case EnableContinuousUpdates:
+			err = s.handleEnableContinuousUpdates(client)
+			if err != nil {
+				l.Warn().Println("EnableContinuousUpdates error:", err)
+				continue
+			}
+		case Fence:
+			err = s.handleFence(client)
+			if err != nil {
+				l.Warn().Println("Fence error:", err)
+				continue
+			}
+		case SetDesktopSize:
+			err = s.handleSetDesktopSize(client)
+			if err != nil {
+				l.Warn().Println("SetDesktopSize error:", err)
+				continue
+			}
 		default:
 			l.Warn().Println("Unsupported message type:", hex.EncodeToString(msgType))
 		}
@@ -498,27 +1220,20 @@ func (s *Server) HandleClient(client *Client) error {
 }
 
 func (s *Server) GetServerInit() (*ServerInit, error) {
-	size, err := s.Video.GetSize()
-	if err != nil {
-		return nil, err
+	size := s.frameSize
+	if size == (image.Point{}) {
+		videoSize, err := s.Video.GetSize()
+		if err != nil {
+			return nil, err
+		}
+		size = image.Point(*videoSize)
 	}
 
 	return &ServerInit{
-		Name:   "OpenKVM",
-		Width:  uint16(size.X),
-		Height: uint16(size.Y),
-		PixelFormat: PixelFormat{
-			BitsPerPixel: 32,
-			Depth:        24,
-			BigEndian:    0,
-			TrueColor:    1,
-			RedMax:       0xff,
-			GreenMax:     0xff,
-			BlueMax:      0xff,
-			RedShift:     16,
-			GreenShift:   8,
-			BlueShift:    0,
-		},
+		Name:        "OpenKVM",
+		Width:       uint16(size.X),
+		Height:      uint16(size.Y),
+		PixelFormat: codec.DefaultPixelFormat,
 	}, nil
 }
 
@@ -531,28 +1246,17 @@ func (s *Server) GetServerInitBytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	pf := si.PixelFormat
 
 	nameBytes := make([]byte, 4)
 	binary.BigEndian.PutUint32(nameBytes, uint32(len(si.Name)))
 	nameBytes = append(nameBytes, []byte(si.Name)...)
 
-	msg := append([]byte{
+	msg := []byte{
 		byte(si.Width >> 8), byte(si.Width),
 		byte(si.Height >> 8), byte(si.Height),
-		pf.Depth,
-		pf.BitsPerPixel,
-		pf.BigEndian,
-		pf.TrueColor,
-		byte(pf.RedMax >> 8), byte(pf.RedMax),
-		byte(pf.GreenMax >> 8), byte(pf.GreenMax),
-		byte(pf.BlueMax >> 8), byte(pf.BlueMax),
-		pf.RedShift,
-		pf.GreenShift,
-		pf.BlueShift,
-		// padding
-		0x00, 0x00, 0x00,
-	}, nameBytes...)
+	}
+	msg = append(msg, si.PixelFormat.Bytes()...)
+	msg = append(msg, nameBytes...)
 
 	s.serverInitBytes = msg
 
@@ -563,37 +1267,65 @@ func New(
 	k keymouse.Driver,
 	v video.Driver,
 	m keymouse.Driver,
-	videoCodec codec.Codec,
+	videoCodecs []codec.Factory,
 	c clipboard.Driver,
 	options Options,
 ) (*Server, error) {
+	if options.Audit == nil {
+		options.Audit = audit.Discard
+	}
+
 	s := &Server{
 		Options: options,
 
-		Keyboard:   k,
-		Video:      v,
-		Mouse:      m,
-		VideoCodec: videoCodec,
-		Clipboard:  c,
+		Keyboard:    k,
+		Video:       v,
+		Mouse:       m,
+		VideoCodecs: videoCodecs,
+		Clipboard:   c,
 
-		locker: &sync.Mutex{},
+		locker:       &sync.Mutex{},
+		resizeLocker: &sync.Mutex{},
+		frames:       video.NewBroadcaster(v),
+
+		Lockout: lockout.New(lockout.Options{
+			MaxFailures: options.Config.VNC.MaxAuthFailures,
+			Duration:    time.Duration(options.Config.VNC.AuthLockout) * time.Second,
+		}),
+
+		clients:       make(map[*Client]struct{}),
+		clientsLocker: &sync.Mutex{},
+		controlLocker: &sync.Mutex{},
 	}
 
 	return s, nil
 }
 
 type Client struct {
-	locker   sync.Locker
-	buffer   []byte
-	leftover []byte
-	timeout  time.Duration
+	locker      sync.Locker
+	writeLocker sync.Locker
+	buffer      []byte
+	leftover    []byte
+	timeout     time.Duration
 
+	securityTypes    []SecurityType
 	respSecurityType SecurityType
 	challenge        []byte
 
+	// user authenticated with, its permissions apply to this connection
+	user config.User
+
+	// fields below are guarded by Server.controlLocker
+	lastInputAt      time.Time
+	pressedKeys      map[uint32]struct{}
+	lastPointerEvent []byte
+
 	framebufferUpdateRequest []byte
-	pixelFormat              []byte
-	encodings                []byte
+	setPixelFormat           []byte
+	setEncodings             []byte
+	setDesktopSize           []byte
+	enableContinuousUpdates  []byte
+	fence                    []byte
 	keyEvent                 []byte
 	pointerEvent             []byte
 	clientCut                []byte
@@ -601,28 +1333,157 @@ type Client struct {
 	fullKeyEvent     []byte
 	fullPointerEvent []byte
 
+	// updateLocker guards the states of framebuffer updates, which are shared with the sender goroutine
+	updateLocker      sync.Locker
+	updateSignal      chan struct{}
+	updateRequested   bool
+	updateIncremental bool
+	updateRegion      image.Rectangle
+
 	previewFrame config.Frame
+	size         image.Point
+	pixelFormat  codec.PixelFormat
+	encodings    []codec.Encoding
+	codec        codec.Codec
+	codecs       map[codec.Encoding]codec.Codec
+
+	// extendedDesktopSize the first time client announced ExtendedDesktopSize,
+	// an ExtendedDesktopSize rect should be sent even if the size is not changed
+	extendedDesktopSize bool
+	pendingDesktopSize  bool
+	desktopSizeReason   int
+	// cropped frames are cropped to size, because client does NOT support DesktopSize
+	cropped bool
+
+	// cursorSent cursor pixels are in the pixel format of client, it should be sent again if pixel format changed
+	cursorSent bool
+
+	continuousUpdates        bool
+	continuousUpdatesEnabled bool
+	continuousUpdatesRegion  image.Rectangle
+
+	fenceSupported  bool
+	pendingFence    []byte
+	pendingFenceDue bool
+	lastPingAt      time.Time
+	latency         atomic.Int64
+
+	lastActivityAt   atomic.Int64
+	bytesSent        atomic.Uint64
+	disconnectReason atomic.Value
+
+	ID          uint64
+	Username    string
+	RemoteAddr  string
+	ConnectedAt time.Time
+
+	// Guest grant of the share token verified by transport, nil for users
+	Guest *guest.Grant
 
 	Messager io.ReadWriteCloser
 }
 
+// Can reports whether the authenticated user of client has permission p
+func (c *Client) Can(p config.Permission) bool {
+	return c.user.Can(p)
+}
+
+// useCodec switches to the codec created by factory,
+// codec instances are kept for the whole connection, so that their states are continuous.
+func (c *Client) useCodec(factory *codec.Factory) {
+	if c.codecs == nil {
+		c.codecs = make(map[codec.Encoding]codec.Codec)
+	}
+
+	cc, ok := c.codecs[factory.Encoding]
+	if !ok {
+		cc = factory.New()
+		c.codecs[factory.Encoding] = cc
+	}
+
+	if setter, ok := cc.(codec.PseudoEncodingsSetter); ok {
+		setter.SetPseudoEncodings(c.encodings)
+	}
+
+	c.codec = cc
+}
+
 func (c *Client) Write(msg []byte) (int, error) {
-	return c.Messager.Write(msg)
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	n, err := c.Messager.Write(msg)
+	c.bytesSent.Add(uint64(n))
+	return n, err
 }
 
+// Latency is the round trip time measured by Fence, 0 if client does not support Fence
+func (c *Client) Latency() time.Duration {
+	return time.Duration(c.latency.Load())
+}
+
+// Close sends reason if it is not empty, and closes the messager.
+// The reason is NOT sent if another write does not finish in CloseReasonTimeout.
 func (c *Client) Close(reason string) error {
 	if reason != "" {
 		length := len(reason)
 		bs := make([]byte, 4)
 		binary.BigEndian.PutUint32(bs, uint32(length))
 
-		// ignore error, close client anyway
-		_, _ = c.Messager.Write(append(bs, []byte(reason)...))
+		// do NOT interleave with a framebuffer update of sender
+		locked := make(chan struct{})
+		go func() {
+			c.writeLocker.Lock()
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+			if conn, ok := c.Messager.(interface{ SetWriteDeadline(time.Time) error }); ok {
+				_ = conn.SetWriteDeadline(time.Now().Add(CloseReasonTimeout))
+			}
+			// ignore error, close client anyway
+			_, _ = c.Messager.Write(append(bs, []byte(reason)...))
+			c.writeLocker.Unlock()
+		case <-time.After(CloseReasonTimeout):
+			l.Warn().Printf("Reason is NOT sent to client %d, it is blocked by another write: %s", c.ID, reason)
+			// the blocked write fails after closing, then the lock is released
+			go func() {
+				<-locked
+				c.writeLocker.Unlock()
+			}()
+		}
 	}
 	return c.Messager.Close()
 }
 
 func (c *Client) Read(dst []byte) error {
+	if c.timeout.Seconds() == 0 {
+		c.timeout = time.Second * 30
+	}
+	return c.ReadWithTimeout(dst, c.timeout)
+}
+
+// discard reads and drops n bytes in chunks
+func (c *Client) discard(n int64) error {
+	_, err := io.CopyN(io.Discard, clientReader{c}, n)
+	return err
+}
+
+// clientReader adapts Client to io.Reader, dst is always filled
+type clientReader struct {
+	*Client
+}
+
+func (r clientReader) Read(dst []byte) (int, error) {
+	err := r.Client.Read(dst)
+	if err != nil {
+		return 0, err
+	}
+	return len(dst), nil
+}
+
+// ReadWithTimeout waits forever if timeout is 0
+func (c *Client) ReadWithTimeout(dst []byte, timeout time.Duration) error {
 	c.locker.Lock()
 	defer c.locker.Unlock()
 
@@ -638,6 +1499,12 @@ func (c *Client) Read(dst []byte) error {
 
 	buf := c.leftover
 	go func() {
+		defer func() {
+			// a messager may panic on broken connection, like websocket after repeated read errors
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic of read: %v", r)
+			}
+		}()
 		for {
 			n, err := c.Messager.Read(c.buffer)
 			if err != nil {
@@ -655,12 +1522,13 @@ func (c *Client) Read(dst []byte) error {
 		}
 	}()
 
-	if c.timeout.Seconds() == 0 {
-		c.timeout = time.Second * 30
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timeoutCh = time.After(timeout)
 	}
 
 	select {
-	case <-time.After(c.timeout):
+	case <-timeoutCh:
 		_ = c.Close("Read timeout")
 		return io.ErrNoProgress
 	case err := <-errCh:
@@ -678,9 +1546,12 @@ func (c *Client) Read(dst []byte) error {
 
 func NewClient(message io.ReadWriteCloser, timeout time.Duration) *Client {
 	return &Client{
-		locker:  &sync.Mutex{},
-		buffer:  make([]byte, 1024),
-		timeout: timeout,
+		locker:       &sync.Mutex{},
+		writeLocker:  &sync.Mutex{},
+		updateLocker: &sync.Mutex{},
+		updateSignal: make(chan struct{}, 1),
+		buffer:       make([]byte, 1024),
+		timeout:      timeout,
 
 		//               +--------------+--------------+--------------+
 		//              | No. of bytes | Type [Value] | Description  |
@@ -689,7 +1560,7 @@ func NewClient(message io.ReadWriteCloser, timeout time.Duration) *Client {
 		//              | 3            |              | padding      |
 		//              | 16           | PIXEL_FORMAT | pixel-format |
 		//              +--------------+--------------+--------------+
-		pixelFormat: make([]byte, 19),
+		setPixelFormat: make([]byte, 19),
 		//            +--------------+--------------+---------------------+
 		//           | No. of bytes | Type [Value] | Description         |
 		//           +--------------+--------------+---------------------+
@@ -698,7 +1569,7 @@ func NewClient(message io.ReadWriteCloser, timeout time.Duration) *Client {
 		//           | 2            | U16          | number-of-encodings |
 		//           | 4            | S32          | encoding-type       |
 		//           +--------------+--------------+---------------------+
-		encodings: make([]byte, 3),
+		setEncodings: make([]byte, 3),
 		//               +--------------+--------------+--------------+
 		//              | No. of bytes | Type [Value] | Description  |
 		//              +--------------+--------------+--------------+
@@ -737,10 +1608,47 @@ func NewClient(message io.ReadWriteCloser, timeout time.Duration) *Client {
 		//              | length       | U8 array     | text         |
 		//              +--------------+--------------+--------------+
 		clientCut: make([]byte, 7),
+		//               +--------------+--------------+---------------------+
+		//              | No. of bytes | Type [Value] | Description         |
+		//              +--------------+--------------+---------------------+
+		//              | 1            | U8 [251]     | message-type        |
+		//              | 1            |              | padding             |
+		//              | 2            | U16          | width               |
+		//              | 2            | U16          | height              |
+		//              | 1            | U8           | number-of-screens   |
+		//              | 1            |              | padding             |
+		//              | 16 * n       | SCREEN array | screens             |
+		//              +--------------+--------------+---------------------+
+		setDesktopSize: make([]byte, 7),
+		//               +--------------+--------------+--------------+
+		//              | No. of bytes | Type [Value] | Description  |
+		//              +--------------+--------------+--------------+
+		//              | 1            | U8 [150]     | message-type |
+		//              | 1            | U8           | enable-flag  |
+		//              | 2            | U16          | x-position   |
+		//              | 2            | U16          | y-position   |
+		//              | 2            | U16          | width        |
+		//              | 2            | U16          | height       |
+		//              +--------------+--------------+--------------+
+		enableContinuousUpdates: make([]byte, 9),
+		//               +--------------+--------------+--------------+
+		//              | No. of bytes | Type [Value] | Description  |
+		//              +--------------+--------------+--------------+
+		//              | 1            | U8 [248]     | message-type |
+		//              | 3            |              | padding      |
+		//              | 4            | U32          | flags        |
+		//              | 1            | U8           | length       |
+		//              | length       | U8 array     | payload      |
+		//              +--------------+--------------+--------------+
+		fence: make([]byte, 8),
 
 		fullKeyEvent:     append([]byte{byte(KeyEvent)}, bytes.Repeat([]byte{0}, 7)...),
 		fullPointerEvent: append([]byte{byte(PointerEvent)}, bytes.Repeat([]byte{0}, 5)...),
 
-		Messager: message,
+		pixelFormat: codec.DefaultPixelFormat,
+		pressedKeys: make(map[uint32]struct{}),
+
+		ConnectedAt: time.Now(),
+		Messager:    message,
 	}
 }
@@ -0,0 +1,99 @@
+package kvm
+
+import (
+	"encoding/binary"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/codec"
+	"github.com/allape/openkvm/kvm/video/dummy"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSetDesktopSize(t *testing.T) {
+	s, _ := New(nil, dummy.NewDriver("", nil), nil, nil, nil, Options{Config: config.Config{Video: config.Video{SliceCount: 4}}})
+
+	cases := []struct {
+		size     config.Size
+		expected int
+	}{
+		{config.Size{X: 1280, Y: 720}, codec.DesktopSizeNoError},
+		// NOT divisible by slice count
+		{config.Size{X: 1366, Y: 768}, codec.DesktopSizeInvalidScreenData},
+		{config.Size{X: 0, Y: 0}, codec.DesktopSizeInvalidScreenData},
+		{config.Size{X: 16384, Y: 720}, codec.DesktopSizeInvalidScreenData},
+	}
+
+	for _, c := range cases {
+		if status := s.setDesktopSize(c.size); status != c.expected {
+			t.Fatalf("%v: expected status %d, got %d", c.size, c.expected, status)
+		}
+	}
+
+	size, _ := s.Video.GetSize()
+	if *size != (config.Size{X: 1280, Y: 720}) {
+		t.Fatalf("expected size of the only accepted request, got %v", *size)
+	}
+}
+
+func TestHandleClientCutDropped(t *testing.T) {
+	s, _ := New(nil, nil, nil, nil, nil, Options{})
+
+	for _, c := range []struct {
+		permission config.Permission
+		length     int
+	}{
+		{config.PermissionView, 1024},
+		{config.PermissionClipboard, MaxClientCutTextLength + 1},
+	} {
+		server, conn := net.Pipe()
+		client := NewClient(server, time.Second)
+		client.user = config.User{Permissions: config.Permissions{c.permission}}
+
+		go func() {
+			msg := binary.BigEndian.AppendUint32([]byte{0, 0, 0}, uint32(c.length))
+			_, _ = conn.Write(append(msg, make([]byte, c.length)...))
+			_, _ = conn.Write([]byte{byte(KeyEvent)})
+		}()
+
+		if err := s.handleClientCut(client); err != nil {
+			t.Fatal(err)
+		}
+
+		// the whole text is drained, so the next message is in place
+		next := make([]byte, 1)
+		if err := client.Read(next); err != nil || next[0] != byte(KeyEvent) {
+			t.Fatalf("%s: expected next message %d, got %v %v", c.permission, KeyEvent, next, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestCloseWaitsForWrite(t *testing.T) {
+	server, conn := net.Pipe()
+	client := NewClient(server, time.Second)
+
+	// sender is in the middle of a write
+	client.writeLocker.Lock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Close("bye")
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("reason should NOT be written during another write")
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	client.writeLocker.Unlock()
+
+	msg := make([]byte, 7)
+	if _, err := io.ReadFull(conn, msg); err != nil || string(msg[4:]) != "bye" {
+		t.Fatalf("expected reason after the write, got %q %v", msg, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package lockout
+
+import (
+	"github.com/allape/gogger"
+	"sync"
+	"time"
+)
+
+var l = gogger.New("kvm.lockout")
+
+const (
+	DefaultMaxFailures = 5
+	DefaultDuration    = 5 * time.Minute
+	// BaseDelay the first back-off after a failure, doubled by every following failure
+	BaseDelay = time.Second
+	// ForgetAfter failures are forgotten after no failure for this long
+	ForgetAfter = time.Hour
+)
+
+type Options struct {
+	// MaxFailures locks the source out after this many failures in a row, 0 means DefaultMaxFailures
+	MaxFailures int
+	// Duration of lockout, 0 means DefaultDuration
+	Duration time.Duration
+}
+
+type record struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// Guard counts auth failures per source, usually IP
+type Guard struct {
+	options Options
+	locker  sync.Locker
+	records map[string]*record
+	now     func() time.Time
+}
+
+// Blocked returns how long source should wait before the next attempt, 0 if it is allowed
+func (g *Guard) Blocked(source string) time.Duration {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	r, ok := g.records[source]
+	if !ok {
+		return 0
+	}
+
+	wait := r.blockedUntil.Sub(g.now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failure of source, locked is true if source is locked out by this failure
+func (g *Guard) Fail(source string) (locked bool) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	now := g.now()
+	g.prune(now)
+
+	r, ok := g.records[source]
+	if !ok {
+		r = &record{}
+		g.records[source] = r
+	}
+
+	r.failures++
+	r.lastFailure = now
+
+	if r.failures >= g.maxFailures() {
+		l.Warn().Printf("Lock %s out for %s after %d auth failures", source, g.duration(), r.failures)
+		r.blockedUntil = now.Add(g.duration())
+		r.failures = 0
+		return true
+	}
+
+	delay := BaseDelay << (r.failures - 1)
+	if delay > g.duration() {
+		delay = g.duration()
+	}
+	r.blockedUntil = now.Add(delay)
+
+	l.Info().Printf("Auth failure %d/%d of %s, back off %s", r.failures, g.maxFailures(), source, delay)
+
+	return false
+}
+
+// Succeed clears failures of source
+func (g *Guard) Succeed(source string) {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	delete(g.records, source)
+}
+
+// prune removes records which are neither blocked nor failed recently
+func (g *Guard) prune(now time.Time) {
+	for source, r := range g.records {
+		if now.After(r.blockedUntil) && now.Sub(r.lastFailure) > ForgetAfter {
+			delete(g.records, source)
+		}
+	}
+}
+
+func (g *Guard) maxFailures() int {
+	if g.options.MaxFailures <= 0 {
+		return DefaultMaxFailures
+	}
+	return g.options.MaxFailures
+}
+
+func (g *Guard) duration() time.Duration {
+	if g.options.Duration <= 0 {
+		return DefaultDuration
+	}
+	return g.options.Duration
+}
+
+func New(options Options) *Guard {
+	return &Guard{
+		options: options,
+		locker:  &sync.Mutex{},
+		records: make(map[string]*record),
+		now:     time.Now,
+	}
+}
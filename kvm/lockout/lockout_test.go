@@ -0,0 +1,69 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGuard(t *testing.T) {
+	now := time.Now()
+
+	g := New(Options{MaxFailures: 3, Duration: time.Minute})
+	g.now = func() time.Time {
+		return now
+	}
+
+	const ip = "192.168.1.2"
+
+	if g.Blocked(ip) != 0 {
+		t.Fatal("unknown source should NOT be blocked")
+	}
+
+	if g.Fail(ip) {
+		t.Fatal("should NOT be locked after the first failure")
+	}
+	if wait := g.Blocked(ip); wait != BaseDelay {
+		t.Fatalf("expected back-off %s, got %s", BaseDelay, wait)
+	}
+
+	now = now.Add(BaseDelay)
+	if g.Blocked(ip) != 0 {
+		t.Fatal("should be allowed after back-off")
+	}
+
+	if g.Fail(ip) {
+		t.Fatal("should NOT be locked after the second failure")
+	}
+	if wait := g.Blocked(ip); wait != 2*BaseDelay {
+		t.Fatalf("expected back-off %s, got %s", 2*BaseDelay, wait)
+	}
+
+	if g.Blocked("192.168.1.3") != 0 {
+		t.Fatal("other sources should NOT be blocked")
+	}
+
+	if !g.Fail(ip) {
+		t.Fatal("should be locked after the third failure")
+	}
+	if wait := g.Blocked(ip); wait != time.Minute {
+		t.Fatalf("expected lockout %s, got %s", time.Minute, wait)
+	}
+
+	now = now.Add(time.Minute)
+	if g.Blocked(ip) != 0 {
+		t.Fatal("should be allowed after lockout")
+	}
+
+	g.Fail(ip)
+	g.Succeed(ip)
+	if g.Blocked(ip) != 0 {
+		t.Fatal("success should clear failures")
+	}
+
+	g.Fail(ip)
+	now = now.Add(ForgetAfter + time.Second)
+	g.Fail("192.168.1.3")
+	if _, ok := g.records[ip]; ok {
+		t.Fatal("old failures should be forgotten")
+	}
+}
@@ -0,0 +1,70 @@
+package kvm
+
+import (
+	"runtime/debug"
+)
+
+// sender sends framebuffer updates to client when a new frame is captured or client requests one,
+// so that encoding does not block the messages from client.
+func (s *Server) sender(client *Client, stop <-chan struct{}) {
+	defer s.recoverClient(client, "sender")
+
+	frames, unsubscribe := s.frames.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-frames:
+		case <-client.updateSignal:
+		}
+
+		err := s.flushUpdate(client)
+		if err != nil {
+			l.Warn().Println("FramebufferUpdate error:", err)
+		}
+	}
+}
+
+// flushUpdate answers the pending FramebufferUpdateRequest, or pushes continuous updates
+func (s *Server) flushUpdate(client *Client) error {
+	client.updateLocker.Lock()
+	defer client.updateLocker.Unlock()
+
+	frame := s.frames.Latest()
+
+	if client.updateRequested {
+		// incremental requests wait until something changes
+		sent, err := s.sendFramebufferUpdate(client, frame, client.updateIncremental, client.updateRegion, client.updateIncremental)
+		if sent || err != nil {
+			// the failed request is dropped, like it is never received
+			client.updateRequested = false
+		}
+		return err
+	}
+
+	if client.continuousUpdatesEnabled {
+		_, err := s.sendFramebufferUpdate(client, frame, true, client.continuousUpdatesRegion, true)
+		return err
+	}
+
+	return nil
+}
+
+// wake tells the sender goroutine to check states of client
+func (c *Client) wake() {
+	select {
+	case c.updateSignal <- struct{}{}:
+	default:
+	}
+}
+
+// recoverClient disconnects client if the goroutine panics, one broken client should NOT crash the whole server.
+// It must be deferred directly, name is the goroutine for logging.
+func (s *Server) recoverClient(client *Client, name string) {
+	if r := recover(); r != nil {
+		l.Error().Printf("Panic in %s of client %d: %v\n%s", name, client.ID, r, debug.Stack())
+		s.disconnect(client, InternalServerError.Error())
+	}
+}
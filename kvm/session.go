@@ -0,0 +1,189 @@
+package kvm
+
+import (
+	"cmp"
+	"github.com/allape/openkvm/kvm/audit"
+	"slices"
+	"time"
+)
+
+// DefaultIdleWarning how long before disconnecting an idle client a Bell is sent as warning
+const DefaultIdleWarning = time.Minute
+
+// ReasonCloser is implemented by messagers which can tell client why the connection is closed,
+// like websocket close frame, RFB itself has no such message after handshake.
+type ReasonCloser interface {
+	CloseWithReason(reason string) error
+}
+
+// watchdog disconnects client when it is idle, connected for too long or its share link expires,
+// a Bell is sent before that.
+func (s *Server) watchdog(client *Client, stop <-chan struct{}) {
+	defer s.recoverClient(client, "watchdog")
+
+	idleTimeout := time.Duration(s.Options.Config.VNC.IdleTimeout) * time.Second
+	maxSession := time.Duration(s.Options.Config.VNC.MaxSession) * time.Second
+	if idleTimeout <= 0 && maxSession <= 0 && client.Guest == nil {
+		return
+	}
+
+	warning := time.Duration(s.Options.Config.VNC.IdleWarning) * time.Second
+	if warning <= 0 {
+		warning = DefaultIdleWarning
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	warned := false
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			var reason string
+			remaining := time.Duration(-1)
+
+			if idleTimeout > 0 {
+				remaining = idleTimeout - now.Sub(client.LastActivityAt())
+				if remaining <= 0 {
+					reason = "Idle timeout"
+				}
+			}
+			if maxSession > 0 {
+				sessionRemaining := maxSession - now.Sub(client.ConnectedAt)
+				if remaining < 0 || sessionRemaining < remaining {
+					remaining = sessionRemaining
+				}
+				if sessionRemaining <= 0 {
+					reason = "Maximum session length reached"
+				}
+			}
+			if client.Guest != nil {
+				guestRemaining := client.Guest.ExpiresAt.Sub(now)
+				if remaining < 0 || guestRemaining < remaining {
+					remaining = guestRemaining
+				}
+				if guestRemaining <= 0 {
+					reason = ShareLinkExpiredReason
+				}
+			}
+
+			if reason != "" {
+				s.disconnect(client, reason)
+				return
+			}
+
+			if remaining > warning {
+				warned = false
+			} else if !warned {
+				warned = true
+				l.Info().Printf("Client %d will be disconnected in %s", client.ID, remaining.Round(time.Second))
+				_, err := client.Write([]byte{byte(Bell)})
+				if err != nil {
+					l.Warn().Println("Bell:", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) disconnect(client *Client, reason string) {
+	l.Info().Printf("Disconnect client %d: %s", client.ID, reason)
+
+	client.disconnectReason.Store(reason)
+
+	var err error
+	if closer, ok := client.Messager.(ReasonCloser); ok {
+		err = closer.CloseWithReason(reason)
+	} else {
+		// RFB has no message to close with a reason after auth, native VNC clients only see a closed connection
+		l.Info().Printf("Reason is NOT sent to client %d, its connection does not support it", client.ID)
+		err = client.Messager.Close()
+	}
+	if err != nil {
+		l.Warn().Printf("Close client %d: %s", client.ID, err)
+	}
+}
+
+// Sessions returns all connected clients, ordered by ID
+func (s *Server) Sessions() []ClientInfo {
+	s.clientsLocker.Lock()
+	clients := make([]*Client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.clientsLocker.Unlock()
+
+	s.controlLocker.Lock()
+	defer s.controlLocker.Unlock()
+
+	sessions := make([]ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		info := c.info()
+		info.Controller = c == s.controller
+		sessions = append(sessions, info)
+	}
+
+	slices.SortFunc(sessions, func(a, b ClientInfo) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return sessions
+}
+
+// Disconnect kicks the client with id out
+func (s *Server) Disconnect(id uint64, reason string) error {
+	client := s.findClient(id)
+	if client == nil {
+		return ClientNotFound
+	}
+	s.disconnect(client, reason)
+	return nil
+}
+
+// DisconnectGuests disconnects clients connected by the share link of grant id, returns the count of them
+func (s *Server) DisconnectGuests(id string, reason string) int {
+	s.clientsLocker.Lock()
+	guests := make([]*Client, 0)
+	for c := range s.clients {
+		if c.Guest != nil && c.Guest.ID == id {
+			guests = append(guests, c)
+		}
+	}
+	s.clientsLocker.Unlock()
+
+	for _, c := range guests {
+		s.disconnect(c, reason)
+	}
+
+	return len(guests)
+}
+
+// LastActivityAt is the time of the last keyboard, mouse or clipboard message, input of view-only clients counts too
+func (c *Client) LastActivityAt() time.Time {
+	at := c.lastActivityAt.Load()
+	if at == 0 {
+		return c.ConnectedAt
+	}
+	return time.Unix(0, at)
+}
+
+func (c *Client) BytesSent() uint64 {
+	return c.bytesSent.Load()
+}
+
+func (c *Client) auditEvent(t audit.EventType) audit.Event {
+	event := audit.Event{
+		Type:       t,
+		Via:        audit.RFB,
+		ClientID:   c.ID,
+		Username:   c.Username,
+		RemoteAddr: c.RemoteAddr,
+	}
+	if c.Guest != nil {
+		event.Guest = c.Guest.ID
+	}
+	return event
+}
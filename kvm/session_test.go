@@ -0,0 +1,30 @@
+package kvm
+
+import (
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/guest"
+	"testing"
+)
+
+func TestDisconnectGuests(t *testing.T) {
+	s, _ := New(nil, nil, nil, nil, nil, Options{})
+
+	user := newTestClient(t, config.PermissionView)
+	revoked := newTestClient(t, config.PermissionView)
+	revoked.Guest = &guest.Grant{ID: "revoked"}
+	other := newTestClient(t, config.PermissionView)
+	other.Guest = &guest.Grant{ID: "other"}
+
+	for _, c := range []*Client{user, revoked, other} {
+		if err := s.join(c, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count := s.DisconnectGuests("revoked", "Share link revoked"); count != 1 {
+		t.Fatalf("expected 1 guest disconnected, got %d", count)
+	}
+	if disconnectReasonOf(revoked) != "Share link revoked" || disconnectReasonOf(user) != "" || disconnectReasonOf(other) != "" {
+		t.Fatal("only guests of the revoked link should be disconnected")
+	}
+}
@@ -0,0 +1,71 @@
+package kvm
+
+import (
+	"github.com/allape/openkvm/config"
+)
+
+// ExclusiveAccessReason is sent to clients disconnected by a client asks for exclusive access
+const ExclusiveAccessReason = "Another client asked for exclusive access"
+
+// join registers client by the share policy, shared is the shared-flag of ClientInit.
+// Other clients are only disconnected when an exclusive one joins, clients join after it are shared with it.
+func (s *Server) join(client *Client, shared bool) error {
+	policy := s.Options.Config.VNC.SharePolicy
+
+	if !shared && alwaysShared(policy, client.user) {
+		l.Info().Printf("Client from %s asks for exclusive access, treat it as shared", client.RemoteAddr)
+		shared = true
+	}
+
+	s.clientsLocker.Lock()
+	defer s.clientsLocker.Unlock()
+
+	if !shared && len(s.clients) > 0 {
+		if policy == config.ShareRefuse {
+			l.Info().Println("Refuse client asks for exclusive access, other clients connected:", len(s.clients))
+			return ExclusiveAccessRefused
+		}
+
+		l.Info().Println("Client asks for exclusive access, disconnect other clients:", len(s.clients))
+		for c := range s.clients {
+			s.disconnect(c, ExclusiveAccessReason)
+			delete(s.clients, c)
+		}
+	}
+
+	client.ID = s.lastClientID.Add(1)
+	s.clients[client] = struct{}{}
+
+	l.Info().Printf("Client %d joined from %s, shared: %v, clients: %d", client.ID, client.RemoteAddr, shared, len(s.clients))
+
+	return nil
+}
+
+// leave removes client, and releases the control if it holds.
+// s.controlLocker is held while removing, so that SetController never gives control to a client which has left.
+func (s *Server) leave(client *Client) {
+	s.controlLocker.Lock()
+	defer s.controlLocker.Unlock()
+
+	if s.controller == client {
+		l.Info().Printf("Client %d releases control", client.ID)
+		s.switchController(nil)
+	}
+
+	s.clientsLocker.Lock()
+	defer s.clientsLocker.Unlock()
+
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+
+	delete(s.clients, client)
+
+	l.Info().Printf("Client %d left, clients: %d", client.ID, len(s.clients))
+}
+
+// alwaysShared tells if user is shared with others whatever its shared-flag is.
+// Only admins can take exclusive access, guests of share links and other users can NOT disconnect anyone.
+func alwaysShared(policy config.SharePolicy, user config.User) bool {
+	return policy == config.ShareAlways || !user.Can(config.PermissionAdmin)
+}
@@ -0,0 +1,97 @@
+package kvm
+
+import (
+	"errors"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/kvm/guest"
+	"net"
+	"testing"
+)
+
+// newTestClient returns a client of a user with permissions on one end of a pipe
+func newTestClient(t *testing.T, permissions ...config.Permission) *Client {
+	server, conn := net.Pipe()
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	client := NewClient(server, 0)
+	client.user = config.User{Permissions: permissions}
+	return client
+}
+
+func disconnectReasonOf(client *Client) string {
+	reason, _ := client.disconnectReason.Load().(string)
+	return reason
+}
+
+func TestAlwaysShared(t *testing.T) {
+	admin := config.User{Permissions: config.Permissions{config.PermissionAdmin}}
+	operator := config.User{Permissions: config.Permissions{config.PermissionView, config.PermissionInput}}
+	controlGuest := guest.Grant{ID: "guest", Control: true}.User()
+
+	cases := []struct {
+		policy   config.SharePolicy
+		user     config.User
+		expected bool
+	}{
+		{config.ShareDisconnect, admin, false},
+		{config.ShareRefuse, admin, false},
+		{config.ShareAlways, admin, true},
+		{config.ShareDisconnect, operator, true},
+		{config.ShareDisconnect, controlGuest, true},
+	}
+
+	for _, c := range cases {
+		if shared := alwaysShared(c.policy, c.user); shared != c.expected {
+			t.Fatalf("%s %v: expected %v, got %v", c.policy, c.user.Permissions, c.expected, shared)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	for _, policy := range []config.SharePolicy{config.ShareDisconnect, config.ShareRefuse} {
+		s, _ := New(nil, nil, nil, nil, nil, Options{Config: config.Config{VNC: config.VNC{SharePolicy: policy}}})
+
+		viewer := newTestClient(t, config.PermissionView)
+		if err := s.join(viewer, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// operators without admin permission are shared
+		if err := s.join(newTestClient(t, config.PermissionInput), false); err != nil || len(s.clients) != 2 {
+			t.Fatalf("%s: exclusive request of operator should be shared, got %v", policy, err)
+		}
+
+		err := s.join(newTestClient(t, config.PermissionAdmin), false)
+		switch policy {
+		case config.ShareDisconnect:
+			if err != nil || len(s.clients) != 1 || disconnectReasonOf(viewer) != ExclusiveAccessReason {
+				t.Fatalf("%s: others should be disconnected, got %v with %d clients", policy, err, len(s.clients))
+			}
+		case config.ShareRefuse:
+			if !errors.Is(err, ExclusiveAccessRefused) || len(s.clients) != 2 {
+				t.Fatalf("%s: expected %v, got %v", policy, ExclusiveAccessRefused, err)
+			}
+		}
+	}
+}
+
+func TestLeaveReleasesControl(t *testing.T) {
+	s, _ := New(nil, nil, nil, nil, nil, Options{})
+
+	client := newTestClient(t, config.PermissionInput)
+	if err := s.join(client, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetController(client.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	s.leave(client)
+	if s.controller != nil {
+		t.Fatal("control should be released after leaving")
+	}
+	if err := s.SetController(client.ID); !errors.Is(err, ClientNotFound) {
+		t.Fatalf("expected %v for a client which has left, got %v", ClientNotFound, err)
+	}
+}
@@ -0,0 +1,219 @@
+package kvm
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// VeNCryptSubtype
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#vencrypt
+type VeNCryptSubtype uint32
+
+const (
+	VeNCryptPlain     VeNCryptSubtype = 256
+	VeNCryptTLSNone   VeNCryptSubtype = 257
+	VeNCryptTLSVnc    VeNCryptSubtype = 258
+	VeNCryptTLSPlain  VeNCryptSubtype = 259
+	VeNCryptX509None  VeNCryptSubtype = 260
+	VeNCryptX509Vnc   VeNCryptSubtype = 261
+	VeNCryptX509Plain VeNCryptSubtype = 262
+)
+
+// veNCryptInnerTypes the security type used inside TLS for each subtype.
+// TLS* subtypes are meant to be anonymous TLS, which is NOT supported by Go,
+// the certificate is sent anyway, clients like TigerVNC accept it without verification.
+var veNCryptInnerTypes = map[VeNCryptSubtype]SecurityType{
+	VeNCryptTLSNone:   None,
+	VeNCryptTLSVnc:    VNCAuthentication,
+	VeNCryptTLSPlain:  Plain,
+	VeNCryptX509None:  None,
+	VeNCryptX509Plain: Plain,
+}
+
+func (s *Server) veNCryptSubtypes() []VeNCryptSubtype {
+	if len(s.Options.Config.VNC.AllUsers()) == 0 {
+		return []VeNCryptSubtype{VeNCryptX509None, VeNCryptTLSNone}
+	}
+
+	var subtypes []VeNCryptSubtype
+	plain, vnc := s.authMethods()
+	if plain {
+		subtypes = append(subtypes, VeNCryptX509Plain, VeNCryptTLSPlain)
+	}
+	if vnc {
+		subtypes = append(subtypes, VeNCryptTLSVnc)
+	}
+	return subtypes
+}
+
+// handleVeNCrypt negotiates the subtype and upgrades the connection to TLS,
+// then respSecurityType is set to the inner security type for auth.
+func (s *Server) handleVeNCrypt(client *Client) error {
+	if s.Options.TLSConfig == nil {
+		return client.Close(InternalServerError.Error())
+	}
+
+	// version 0.2
+	_, err := client.Write([]byte{0, 2})
+	if err != nil {
+		return err
+	}
+
+	version := make([]byte, 2)
+	err = client.Read(version)
+	if err != nil {
+		return err
+	}
+
+	if version[0] != 0 || version[1] != 2 {
+		_, _ = client.Write([]byte{1})
+		_ = client.Messager.Close()
+		return UnsupportedAuthType
+	}
+
+	//  +--------------+--------------+--------------------+
+	// | No. of bytes | Type [Value] | Description        |
+	// +--------------+--------------+--------------------+
+	// | 1            | U8 [0]       | ack                |
+	// | 1            | U8           | number-of-subtypes |
+	// | 4 * n        | U32 array    | subtypes           |
+	// +--------------+--------------+--------------------+
+	subtypes := s.veNCryptSubtypes()
+	msg := []byte{0, byte(len(subtypes))}
+	for _, subtype := range subtypes {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(subtype))
+	}
+	_, err = client.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	chosen := make([]byte, 4)
+	err = client.Read(chosen)
+	if err != nil {
+		return err
+	}
+
+	subtype := VeNCryptSubtype(binary.BigEndian.Uint32(chosen))
+	if !slices.Contains(subtypes, subtype) {
+		_, _ = client.Write([]byte{0})
+		_ = client.Messager.Close()
+		return UnsupportedAuthType
+	}
+
+	_, err = client.Write([]byte{1})
+	if err != nil {
+		return err
+	}
+
+	conn := tls.Server(newClientConn(client), s.Options.TLSConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+	defer cancel()
+
+	err = conn.HandshakeContext(ctx)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	l.Debug().Printf("VeNCrypt subtype %d with %s", subtype, tls.VersionName(conn.ConnectionState().Version))
+
+	client.Messager = conn
+
+	client.respSecurityType = veNCryptInnerTypes[subtype]
+	if client.respSecurityType == VNCAuthentication {
+		return s.sendChallenge(client)
+	}
+
+	return nil
+}
+
+// clientConn adapts the messager of client to net.Conn for TLS,
+// bytes already buffered by client are taken over and read first.
+type clientConn struct {
+	client   *Client
+	messager io.ReadWriteCloser
+	leftover []byte
+}
+
+func newClientConn(client *Client) *clientConn {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+
+	leftover := client.leftover
+	client.leftover = nil
+
+	return &clientConn{
+		client:   client,
+		messager: client.Messager,
+		leftover: leftover,
+	}
+}
+
+func (c *clientConn) Read(dst []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(dst, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+	return c.messager.Read(dst)
+}
+
+func (c *clientConn) Write(src []byte) (int, error) {
+	return c.messager.Write(src)
+}
+
+func (c *clientConn) Close() error {
+	return c.messager.Close()
+}
+
+func (c *clientConn) LocalAddr() net.Addr {
+	if conn, ok := c.messager.(net.Conn); ok {
+		return conn.LocalAddr()
+	}
+	return clientAddr("")
+}
+
+func (c *clientConn) RemoteAddr() net.Addr {
+	if conn, ok := c.messager.(net.Conn); ok {
+		return conn.RemoteAddr()
+	}
+	return clientAddr(c.client.RemoteAddr)
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	if conn, ok := c.messager.(net.Conn); ok {
+		return conn.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	if conn, ok := c.messager.(net.Conn); ok {
+		return conn.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	if conn, ok := c.messager.(net.Conn); ok {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+type clientAddr string
+
+func (a clientAddr) Network() string {
+	return "rfb"
+}
+
+func (a clientAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,115 @@
+package kvm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"github.com/allape/openkvm/config"
+	"github.com/allape/openkvm/crypto/selfsigned"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// ackConn sends the chosen subtype along with ClientHello, so the server has buffered bytes to hand over to TLS
+type ackConn struct {
+	net.Conn
+	chosen []byte
+	acked  bool
+}
+
+func (c *ackConn) Write(src []byte) (int, error) {
+	if c.chosen != nil {
+		src, c.chosen = append(c.chosen, src...), nil
+		_, err := c.Conn.Write(src)
+		return len(src) - 4, err
+	}
+	return c.Conn.Write(src)
+}
+
+func (c *ackConn) Read(dst []byte) (int, error) {
+	if !c.acked {
+		ack := make([]byte, 1)
+		if _, err := io.ReadFull(c.Conn, ack); err != nil || ack[0] != 1 {
+			return 0, errors.New("subtype is NOT accepted")
+		}
+		c.acked = true
+	}
+	return c.Conn.Read(dst)
+}
+
+func TestVeNCrypt(t *testing.T) {
+	cert, err := selfsigned.LoadOrGenerate("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Options: Options{
+		Config:    config.Config{VNC: config.VNC{Users: []config.User{{Username: "openkvm", Password: "secret"}}}},
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}}
+
+	offered := []byte{0, 3, 0, 0, 1, 6, 0, 0, 1, 3, 0, 0, 1, 2} // X509Plain, TLSPlain, TLSVnc
+
+	for _, c := range []struct {
+		subtype  VeNCryptSubtype
+		inner    SecurityType
+		rejected bool
+	}{
+		{VeNCryptTLSVnc, VNCAuthentication, false},
+		{VeNCryptX509Plain, Plain, false},
+		// NOT offered
+		{VeNCryptX509Vnc, None, true},
+	} {
+		server, conn := net.Pipe()
+		client := NewClient(server, time.Second)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.handleVeNCrypt(client)
+		}()
+
+		msg := make([]byte, 2+len(offered))
+		_, _ = io.ReadFull(conn, msg[:2])
+		_, _ = conn.Write([]byte{0, 2})
+		_, _ = io.ReadFull(conn, msg[2:])
+		if !bytes.Equal(msg, append([]byte{0, 2}, offered...)) {
+			t.Fatalf("%d: unexpected version and subtypes %v", c.subtype, msg)
+		}
+
+		chosen := binary.BigEndian.AppendUint32(nil, uint32(c.subtype))
+
+		if c.rejected {
+			_, _ = conn.Write(chosen)
+			ack := make([]byte, 1)
+			_, _ = io.ReadFull(conn, ack)
+			if err := <-done; ack[0] != 0 || !errors.Is(err, UnsupportedAuthType) {
+				t.Fatalf("%d: expected rejected with %v, got %v %v", c.subtype, UnsupportedAuthType, ack, err)
+			}
+			continue
+		}
+
+		tlsConn := tls.Client(&ackConn{Conn: conn, chosen: chosen}, &tls.Config{InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+
+		if c.inner == VNCAuthentication {
+			challenge := make([]byte, ChallengeSize)
+			_, _ = io.ReadFull(tlsConn, challenge)
+			if !bytes.Equal(challenge, client.challenge) {
+				t.Fatalf("%d: challenge should be sent over TLS", c.subtype)
+			}
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if client.respSecurityType != c.inner {
+			t.Fatalf("%d: expected inner security type %d, got %d", c.subtype, c.inner, client.respSecurityType)
+		}
+		_ = conn.Close()
+	}
+}
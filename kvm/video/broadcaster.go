@@ -0,0 +1,118 @@
+package video
+
+import (
+	"github.com/allape/gogger"
+	"github.com/allape/openkvm/config"
+	"sync"
+	"time"
+)
+
+var l = gogger.New("kvm.video")
+
+// Broadcaster captures frames from a driver in a single loop, and shares them with all subscribers.
+// The loop only runs while there is at least one subscriber.
+type Broadcaster struct {
+	driver Driver
+
+	locker      sync.Locker
+	subscribers map[chan config.Frame]struct{}
+	latest      config.Frame
+	stop        chan struct{}
+}
+
+// Subscribe returns a channel which receives the latest frame when it changes.
+// Frames are dropped if the subscriber is slower than the capture loop, only the latest one is kept.
+func (b *Broadcaster) Subscribe() (frames <-chan config.Frame, unsubscribe func()) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	ch := make(chan config.Frame, 1)
+	b.subscribers[ch] = struct{}{}
+
+	if b.stop == nil {
+		b.stop = make(chan struct{})
+		go b.loop(b.stop)
+	}
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			b.locker.Lock()
+			defer b.locker.Unlock()
+
+			delete(b.subscribers, ch)
+			if len(b.subscribers) == 0 && b.stop != nil {
+				close(b.stop)
+				b.stop = nil
+				// video is closed without subscribers, the next one should NOT see a frame of last session
+				b.latest = nil
+			}
+		})
+	}
+}
+
+// Latest returns the last captured frame, nil if nothing has been captured
+func (b *Broadcaster) Latest() config.Frame {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.latest
+}
+
+func (b *Broadcaster) loop(stop <-chan struct{}) {
+	frameRate := b.driver.GetFrameRate()
+	if frameRate <= 0 {
+		frameRate = 30
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / frameRate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		frame, err := b.driver.NextFrame()
+		if err != nil {
+			l.Warn().Println("next frame:", err)
+			continue
+		}
+
+		b.publish(frame, stop)
+	}
+}
+
+func (b *Broadcaster) publish(frame config.Frame, stop <-chan struct{}) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	if b.stop != stop {
+		// frame captured before the loop is stopped
+		return
+	}
+
+	if frame == nil || frame == b.latest {
+		return
+	}
+
+	b.latest = frame
+
+	for ch := range b.subscribers {
+		// drop the unread one, this is the only sender, so it never blocks
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- frame
+	}
+}
+
+func NewBroadcaster(driver Driver) *Broadcaster {
+	return &Broadcaster{
+		driver:      driver,
+		locker:      &sync.Mutex{},
+		subscribers: make(map[chan config.Frame]struct{}),
+	}
+}
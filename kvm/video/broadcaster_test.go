@@ -0,0 +1,61 @@
+package video
+
+import (
+	"github.com/allape/openkvm/config"
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingDriver struct {
+	Driver
+	calls atomic.Int32
+}
+
+func (d *countingDriver) GetFrameRate() float64 {
+	return 100
+}
+
+func (d *countingDriver) NextFrame() (config.Frame, error) {
+	d.calls.Add(1)
+	return image.NewRGBA(image.Rect(0, 0, 1, 1)), nil
+}
+
+func TestBroadcaster(t *testing.T) {
+	driver := &countingDriver{}
+	b := NewBroadcaster(driver)
+
+	frames1, unsubscribe1 := b.Subscribe()
+	frames2, unsubscribe2 := b.Subscribe()
+
+	for _, frames := range []<-chan config.Frame{frames1, frames2} {
+		select {
+		case frame := <-frames:
+			if frame == nil {
+				t.Fatal("frame should not be nil")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no frame received")
+		}
+	}
+
+	if b.Latest() == nil {
+		t.Fatal("latest frame should not be nil")
+	}
+
+	unsubscribe1()
+	unsubscribe1()
+	unsubscribe2()
+
+	// wait for the loop to stop
+	time.Sleep(50 * time.Millisecond)
+	calls := driver.calls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if driver.calls.Load() != calls {
+		t.Fatal("capture loop should stop without subscribers")
+	}
+	if b.Latest() != nil {
+		t.Fatal("latest frame should be dropped without subscribers")
+	}
+}
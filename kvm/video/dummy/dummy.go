@@ -5,6 +5,7 @@ import (
 	"github.com/allape/openkvm/helper/placeholder"
 	"github.com/allape/openkvm/kvm/video"
 	"image/color"
+	"sync"
 	"time"
 )
 
@@ -15,6 +16,9 @@ type Driver struct {
 	lastFrame config.Frame
 	lastTime  int64
 
+	// locker guards size and frame, SetSize is called while frames are being read
+	locker sync.Locker
+
 	Width     int
 	Height    int
 	FrameRate float64
@@ -33,10 +37,30 @@ func (d *Driver) GetFrameRate() float64 {
 }
 
 func (d *Driver) GetSize() (*config.Size, error) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
 	return &config.Size{X: d.Width, Y: d.Height}, nil
 }
 
+func (d *Driver) SetSize(size config.Size) error {
+	if size.X <= 0 || size.Y <= 0 {
+		return video.InvalidSize
+	}
+
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
+	d.Width = size.X
+	d.Height = size.Y
+	d.lastTime = 0
+	return nil
+}
+
 func (d *Driver) NextFrame() (config.Frame, error) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+
 	now := time.Now().UnixMilli()
 	if now-d.lastTime <= int64(1000/d.FrameRate) {
 		return d.lastFrame, nil
@@ -81,6 +105,7 @@ func NewDriver(src string, options *Options) video.Driver {
 
 	return &Driver{
 		src:       src,
+		locker:    &sync.Mutex{},
 		Width:     options.Width,
 		Height:    options.Height,
 		FrameRate: options.FrameRate,
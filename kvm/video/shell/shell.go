@@ -3,6 +3,7 @@ package shell
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"github.com/allape/gogger"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/kvm/video"
@@ -15,6 +16,11 @@ import (
 
 var l = gogger.New("kvm.video.shell")
 
+// OpenTimeout is how long Open waits for the first frame of the capture command
+const OpenTimeout = 10 * time.Second
+
+var NoFrameBeforeExit = errors.New("capture command exited before the first frame")
+
 type Driver struct {
 	video.Driver
 
@@ -27,6 +33,10 @@ type Driver struct {
 	frameBuffer          []byte
 	bufferLocker         sync.Locker
 	frameBufferUpdatedAt int64
+	// generation is increased when the process is killed,
+	// frames read by the reader of an old process are dropped.
+	// It is guarded by bufferLocker, so are Width and Height.
+	generation uint64
 
 	lastTime           int64
 	lastFrame          config.Frame
@@ -48,7 +58,12 @@ func (d *Driver) Open() error {
 		return nil
 	}
 
-	cmd, err := d.src.ToCommand()
+	d.bufferLocker.Lock()
+	generation := d.generation
+	width, height := d.Width, d.Height
+	d.bufferLocker.Unlock()
+
+	cmd, err := d.src.WithSize(width, height).ToCommand()
 	if err != nil {
 		return err
 	} else if cmd == nil {
@@ -65,8 +80,12 @@ func (d *Driver) Open() error {
 	}
 
 	readyChan := make(chan struct{}, 1)
+	// closed when stdout is closed, usually the process exited
+	exitChan := make(chan struct{})
 
 	go func() {
+		defer close(exitChan)
+
 		ready := false
 		started := false
 
@@ -110,6 +129,11 @@ func (d *Driver) Open() error {
 				if index != -1 {
 					index = index + len(d.EndMarker)
 					d.bufferLocker.Lock()
+					if generation != d.generation {
+						// process is killed, maybe with a different size
+						d.bufferLocker.Unlock()
+						return
+					}
 					d.frameBuffer = append(frameBuffer, seg[:index]...)
 					d.frameBufferUpdatedAt = time.Now().UnixMicro()
 					l.Verbose().Println("frame updated")
@@ -131,7 +155,8 @@ func (d *Driver) Open() error {
 		for {
 			n, err := stderr.Read(buf)
 			if err != nil {
-				if !errors.Is(err, io.EOF) {
+				// pipes are closed by Wait if the command fails to start
+				if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
 					l.Error().Println(err)
 				}
 				return
@@ -141,7 +166,7 @@ func (d *Driver) Open() error {
 	}()
 
 	for _, command := range d.setupCommands {
-		setup, err := command.ToCommand()
+		setup, err := command.WithSize(width, height).ToCommand()
 		if err != nil {
 			return err
 		}
@@ -161,11 +186,25 @@ func (d *Driver) Open() error {
 		return err
 	}
 
-	d.process = cmd.Process
+	select {
+	case <-readyChan:
+		d.process = cmd.Process
+		return nil
+	case <-exitChan:
+		err = NoFrameBeforeExit
+	case <-time.After(OpenTimeout):
+		err = fmt.Errorf("no frame from capture command in %s", OpenTimeout)
+	}
 
-	<-readyChan
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
 
-	return nil
+	// drop frames of the killed process, if there is any
+	d.bufferLocker.Lock()
+	d.generation++
+	d.bufferLocker.Unlock()
+
+	return err
 }
 
 func (d *Driver) Close() error {
@@ -183,6 +222,18 @@ func (d *Driver) Close() error {
 
 	d.process = nil
 
+	// frames of this process should NOT be seen after re-opening
+	d.nextFrameLocker.Lock()
+	d.bufferLocker.Lock()
+	d.generation++
+	d.frameBuffer = nil
+	d.frameBufferUpdatedAt = 0
+	d.lastFrame = nil
+	d.lastTime = 0
+	d.nextFrameInvokedAt = 0
+	d.bufferLocker.Unlock()
+	d.nextFrameLocker.Unlock()
+
 	return nil
 }
 
@@ -191,9 +242,77 @@ func (d *Driver) GetFrameRate() float64 {
 }
 
 func (d *Driver) GetSize() (*config.Size, error) {
+	d.bufferLocker.Lock()
+	defer d.bufferLocker.Unlock()
+
 	return &config.Size{X: d.Width, Y: d.Height}, nil
 }
 
+// SetSize restarts the capture command with new size, only works when src contains size placeholders.
+// The previous size is restored if the command fails to start with the new size.
+func (d *Driver) SetSize(size config.Size) error {
+	if !d.src.Resizable() {
+		return video.ResizeNotSupported
+	}
+	if size.X <= 0 || size.Y <= 0 {
+		return video.InvalidSize
+	}
+
+	previous, err := d.GetSize()
+	if err != nil {
+		return err
+	}
+
+	d.locker.Lock()
+	running := d.process != nil
+	d.locker.Unlock()
+
+	if running {
+		err = d.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.resize(size)
+
+	if !running {
+		return nil
+	}
+
+	err = d.Open()
+	if err == nil {
+		return nil
+	}
+
+	l.Warn().Printf("Restore video size to %dx%d: %s", previous.X, previous.Y, err)
+
+	d.resize(*previous)
+	if restoreErr := d.Open(); restoreErr != nil {
+		l.Error().Println("Failed to restart capture command with previous size:", restoreErr)
+	}
+
+	return err
+}
+
+func (d *Driver) resize(size config.Size) {
+	d.nextFrameLocker.Lock()
+	d.bufferLocker.Lock()
+	// frames of old size in flight are dropped, even if the process is NOT running
+	d.generation++
+	d.Width = size.X
+	d.Height = size.Y
+	d.frameBuffer = nil
+	d.frameBufferUpdatedAt = 0
+	d.lastFrame = nil
+	d.lastTime = 0
+	d.nextFrameInvokedAt = 0
+	d.bufferLocker.Unlock()
+	d.nextFrameLocker.Unlock()
+
+	l.Info().Printf("Video size changed to %dx%d", size.X, size.Y)
+}
+
 func (d *Driver) NextFrame() (config.Frame, error) {
 	d.nextFrameLocker.Lock()
 	defer d.nextFrameLocker.Unlock()
@@ -205,8 +324,10 @@ func (d *Driver) NextFrame() (config.Frame, error) {
 
 	d.lastTime = now
 
+	d.bufferLocker.Lock()
 	buf := d.frameBuffer
 	updatedAt := d.frameBufferUpdatedAt
+	d.bufferLocker.Unlock()
 
 	if buf == nil {
 		return nil, nil
@@ -255,7 +376,7 @@ func NewDriver(src config.VideoShellSrc, options *Options) video.Driver {
 		nextFrameLocker: &sync.Mutex{},
 
 		Width:       options.Width,
-		Height:      options.Width,
+		Height:      options.Height,
 		FrameRate:   options.FrameRate,
 		StartMarker: []byte{0xff, 0xd8},
 		EndMarker:   []byte{0xff, 0xd9},
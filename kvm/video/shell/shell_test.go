@@ -1,6 +1,7 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/kvm/video"
@@ -92,3 +93,32 @@ func TestDriver(t *testing.T) {
 		time.Sleep(35 * time.Millisecond)
 	}
 }
+
+func TestSetSizeRestoresOnFailure(t *testing.T) {
+	// a frame of markers only is printed at 640x480, other sizes exit immediately
+	driver := NewDriver(
+		[]string{"sh", "-c", `[ {width}x{height} = 640x480 ] && printf '\377\330\377\331' && exec sleep 10`},
+		&Options{Options: video.Options{Width: 640, Height: 480}},
+	)
+
+	err := driver.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = driver.Close()
+	}()
+
+	resizer := driver.(video.Resizer)
+	if err = resizer.SetSize(config.Size{X: 1366, Y: 768}); !errors.Is(err, NoFrameBeforeExit) {
+		t.Fatalf("expected %v, got %v", NoFrameBeforeExit, err)
+	}
+
+	size, _ := driver.GetSize()
+	if *size != (config.Size{X: 640, Y: 480}) {
+		t.Fatalf("expected previous size restored, got %v", *size)
+	}
+	if driver.(*Driver).process == nil {
+		t.Fatal("capture command should be restarted with previous size")
+	}
+}
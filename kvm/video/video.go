@@ -1,9 +1,15 @@
 package video
 
 import (
+	"errors"
 	"github.com/allape/openkvm/config"
 )
 
+var (
+	ResizeNotSupported = errors.New("resize is not supported by this video source")
+	InvalidSize        = errors.New("invalid size")
+)
+
 type (
 	Changed bool
 )
@@ -18,6 +24,11 @@ type Driver interface {
 	NextFrame() (config.Frame, error)
 }
 
+// Resizer is implemented by drivers which can change the capture resolution on the fly
+type Resizer interface {
+	SetSize(size config.Size) error
+}
+
 type Options struct {
 	Width         int
 	Height        int
@@ -2,14 +2,19 @@ package main
 
 import (
 	_ "embed"
+	"errors"
 	"github.com/allape/gogger"
 	"github.com/allape/openkvm/config"
 	"github.com/allape/openkvm/factory"
 	"github.com/allape/openkvm/kvm"
+	"github.com/allape/openkvm/kvm/audit"
 	"github.com/allape/openkvm/kvm/button"
+	"github.com/allape/openkvm/kvm/guest"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -41,14 +46,14 @@ var (
 	TestKeyboardHTML string
 )
 
-func serveHTML(group *gin.RouterGroup, uri, content, filePath string) {
-	group.GET(uri, func(context *gin.Context) {
+func serveHTML(group *gin.RouterGroup, uri, content, filePath string, handlers ...gin.HandlerFunc) {
+	group.GET(uri, append(handlers, func(context *gin.Context) {
 		if stat, err := os.Stat(filePath); err == nil && !stat.IsDir() {
 			context.File(filePath)
 		} else {
 			context.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
 		}
-	})
+	})...)
 }
 
 func main() {
@@ -57,6 +62,13 @@ func main() {
 		l.Error().Fatalln("init logger:", err)
 	}
 
+	if ok, err := RunCommand(os.Args[1:]); ok {
+		if err != nil {
+			l.Error().Fatalln(os.Args[1]+":", err)
+		}
+		return
+	}
+
 	conf, err := config.GetConfig()
 	if err != nil {
 		l.Error().Fatalln("get config:", err)
@@ -112,13 +124,52 @@ func main() {
 		}
 	}()
 
-	videoCodec, err := factory.VideoCodecFromConfig(conf)
+	videoCodecs, err := factory.VideoCodecsFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("video codecs from config:", err)
+	}
+
+	cursor, err := factory.CursorFromConfig(conf)
 	if err != nil {
-		l.Error().Fatalln("video codec from config:", err)
+		l.Error().Fatalln("cursor from config:", err)
 	}
 
-	server, err := kvm.New(k, v, m, videoCodec, clipboard, kvm.Options{
-		Config: conf,
+	tlsConfig, err := factory.TLSConfigFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("tls config from config:", err)
+	}
+
+	webTLSConfig, err := factory.WebTLSConfigFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("web tls config from config:", err)
+	}
+
+	auditor, err := factory.AuditFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("audit from config:", err)
+	}
+	defer func() {
+		_ = auditor.Close()
+	}()
+
+	// there is no reload yet, config is only loaded at start
+	auditor.Log(audit.Event{Type: audit.ConfigLoad, Path: config.Path()})
+
+	issuer, err := factory.GuestIssuerFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("guest issuer from config:", err)
+	}
+
+	shareBaseURL, err := factory.ShareBaseURLFromConfig(conf)
+	if err != nil {
+		l.Error().Fatalln("share base url from config:", err)
+	}
+
+	server, err := kvm.New(k, v, m, videoCodecs, clipboard, kvm.Options{
+		Config:    conf,
+		Cursor:    cursor,
+		TLSConfig: tlsConfig,
+		Audit:     auditor,
 	})
 	if err != nil {
 		l.Error().Fatalln("new kvm:", err)
@@ -126,7 +177,15 @@ func main() {
 
 	upgrader := websocket.Upgrader{}
 
-	engine := gin.Default()
+	engine := gin.New()
+	// share tokens in websocket query are NOT logged
+	engine.Use(gin.LoggerWithFormatter(LogFormatter), gin.Recovery())
+
+	// client IP is used for lockout and audit, do NOT trust X-Forwarded-For from everyone
+	err = engine.SetTrustedProxies(conf.Websocket.TrustedProxies)
+	if err != nil {
+		l.Error().Fatalln("trusted proxies:", err)
+	}
 
 	if conf.Websocket.Cors {
 		upgrader.CheckOrigin = func(r *http.Request) bool {
@@ -137,16 +196,11 @@ func main() {
 
 	clientCount := atomic.Int64{}
 
-	handleWebsocket := func(context *gin.Context) {
-		conn, err := upgrader.Upgrade(context.Writer, context.Request, nil)
-		if err != nil {
-			l.Error().Println("upgrade:", err)
-			context.String(http.StatusInternalServerError, "Internal Server Error: upgrader")
-			return
-		}
+	timeout := time.Duration(conf.Websocket.Timeout) * time.Second
 
+	handleClient := func(client *kvm.Client) {
 		defer func() {
-			_ = conn.Close()
+			_ = client.Messager.Close()
 			l.Debug().Println("client disconnected")
 			if clientCount.Add(-1) == 0 {
 				l.Info().Println("no client left, closing video")
@@ -157,30 +211,81 @@ func main() {
 		l.Debug().Println("client connected")
 
 		clientCount.Add(1)
-		err = v.Open()
+		err := v.Open()
 		if err != nil {
 			l.Error().Println("open video:", err)
 			return
 		}
-		err = server.HandleClient(Websockets2KVMClient(conn, time.Duration(conf.Websocket.Timeout)*time.Second))
+		err = server.HandleClient(client)
 		if err != nil {
 			l.Warn().Println("handle client:", err)
 		}
 	}
 
-	var basicAuth gin.HandlerFunc = func(context *gin.Context) {
-		// no auth
+	handleWebsocket := func(context *gin.Context) {
+		var grant *guest.Grant
+		if token := context.Query(ShareTokenQuery); token != "" {
+			ip := context.ClientIP()
+			if wait := server.Lockout.Blocked(ip); wait > 0 {
+				context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				context.String(http.StatusTooManyRequests, "too many authentication failures")
+				return
+			}
+
+			g, err := issuer.Verify(token)
+			if err != nil {
+				event := AuditEvent(context, audit.AuthFail)
+				event.Guest = g.ID
+				event.Reason = err.Error()
+				auditor.Log(event)
+
+				// expired or revoked tokens are NOT guessed ones
+				guessed := !errors.Is(err, guest.TokenExpired) && !errors.Is(err, guest.TokenRevoked)
+				if guessed && server.Lockout.Fail(ip) {
+					event.Type = audit.Lockout
+					event.Reason = kvm.TooManyAuthFailuresReason
+					auditor.Log(event)
+				}
+
+				context.String(http.StatusUnauthorized, err.Error())
+				return
+			}
+			grant = &g
+		}
+
+		conn, err := upgrader.Upgrade(context.Writer, context.Request, nil)
+		if err != nil {
+			l.Error().Println("upgrade:", err)
+			context.String(http.StatusInternalServerError, "Internal Server Error: upgrader")
+			return
+		}
+
+		client := Websockets2KVMClient(conn, timeout)
+		client.RemoteAddr = context.ClientIP()
+		client.Guest = grant
+		handleClient(client)
 	}
-	if conf.VNC.Username != "" && conf.VNC.Password != "" {
-		basicAuth = gin.BasicAuth(gin.Accounts{
-			conf.VNC.Username: conf.VNC.Password,
-		})
+
+	if conf.VNC.Listen != "" {
+		listener, err := net.Listen("tcp", conf.VNC.Listen)
+		if err != nil {
+			l.Error().Fatalln("listen vnc:", err)
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+
+		l.Info().Println("vnc listening on", listener.Addr())
+
+		go ServeTCP(listener, timeout, handleClient)
 	}
 
+	basicAuth := BasicAuth(conf.VNC.AllUsers(), server.Lockout, auditor)
+
 	engine.GET(conf.Websocket.Path, handleWebsocket)
 
 	apiGroup := engine.Group("/api", basicAuth)
-	apiGroup.GET("/led", func(context *gin.Context) {
+	apiGroup.GET("/led", RequirePermission(config.PermissionInput), func(context *gin.Context) {
 		state := context.Query("state")
 		if state == "on" {
 			_ = k.SendPointerEvent([]byte{'a', '1'})
@@ -190,7 +295,7 @@ func main() {
 
 		context.String(http.StatusOK, "ok")
 	})
-	apiGroup.GET("/button", func(context *gin.Context) {
+	apiGroup.GET("/button", RequirePermission(config.PermissionPower), func(context *gin.Context) {
 		if b == nil {
 			context.String(http.StatusNotImplemented, "not implemented")
 			return
@@ -221,6 +326,11 @@ func main() {
 			return
 		}
 
+		event := AuditEvent(context, audit.Button)
+		event.Button = t
+		event.Duration = dur.Milliseconds()
+		auditor.Log(event)
+
 		time.Sleep(dur)
 
 		err = b.Release(button.Type(t))
@@ -232,9 +342,123 @@ func main() {
 		context.String(http.StatusOK, "ok")
 	})
 
+	apiGroup.GET("/control", RequirePermission(config.PermissionView), func(context *gin.Context) {
+		context.JSON(http.StatusOK, gin.H{
+			"controller": server.Controller(),
+		})
+	})
+	apiGroup.POST("/control", RequirePermission(config.PermissionAdmin), func(context *gin.Context) {
+		// id of client to give control to, 0 to release control
+		id, err := strconv.ParseUint(context.Query("id"), 10, 64)
+		if err != nil {
+			context.String(http.StatusBadRequest, "invalid id")
+			return
+		}
+
+		err = server.SetController(id)
+		if errors.Is(err, kvm.ClientNotFound) {
+			context.String(http.StatusNotFound, "client not found")
+			return
+		} else if errors.Is(err, kvm.PermissionDenied) {
+			context.String(http.StatusForbidden, "client has no input permission")
+			return
+		} else if err != nil {
+			context.String(http.StatusInternalServerError, "set controller: %s", err.Error())
+			return
+		}
+
+		context.String(http.StatusOK, "ok")
+	})
+
+	apiGroup.GET("/sessions", RequirePermission(config.PermissionAdmin), func(context *gin.Context) {
+		context.JSON(http.StatusOK, server.Sessions())
+	})
+	apiGroup.DELETE("/sessions/:id", RequirePermission(config.PermissionAdmin), func(context *gin.Context) {
+		id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+		if err != nil {
+			context.String(http.StatusBadRequest, "invalid id")
+			return
+		}
+
+		reason := context.Query("reason")
+		if reason == "" {
+			reason = "Disconnected by admin"
+		}
+
+		err = server.Disconnect(id, reason)
+		if errors.Is(err, kvm.ClientNotFound) {
+			context.String(http.StatusNotFound, "client not found")
+			return
+		} else if err != nil {
+			context.String(http.StatusInternalServerError, "disconnect: %s", err.Error())
+			return
+		}
+
+		event := AuditEvent(context, audit.Kick)
+		event.Target = id
+		event.Reason = reason
+		auditor.Log(event)
+
+		context.String(http.StatusOK, "ok")
+	})
+
+	apiGroup.POST("/share", RequirePermission(config.PermissionAdmin), func(context *gin.Context) {
+		// lifetime of the link in seconds
+		ttl, err := strconv.Atoi(context.Query("ttl"))
+		if err != nil {
+			context.String(http.StatusBadRequest, "invalid ttl")
+			return
+		}
+
+		// keyboard, mouse and clipboard are allowed, otherwise view-only
+		control := context.Query("control") == "true"
+
+		token, grant, err := issuer.Issue(time.Duration(ttl)*time.Second, control)
+		if errors.Is(err, guest.InvalidTTL) {
+			context.String(http.StatusBadRequest, "ttl should be between 1 and %d seconds", int(issuer.MaxTTL().Seconds()))
+			return
+		} else if err != nil {
+			context.String(http.StatusInternalServerError, "issue share token: %s", err.Error())
+			return
+		}
+
+		event := AuditEvent(context, audit.Share)
+		event.Guest = grant.ID
+		event.Duration = int64(ttl) * 1000
+		event.Control = control
+		auditor.Log(event)
+
+		result := gin.H{
+			"id":         grant.ID,
+			"token":      token,
+			"control":    grant.Control,
+			"expires_at": grant.ExpiresAt,
+		}
+		if conf.VNC.Path != "" {
+			result["url"] = ShareURL(context.Request, shareBaseURL, conf.Websocket.Path, token, !control)
+		}
+
+		context.JSON(http.StatusOK, result)
+	})
+	apiGroup.DELETE("/share/:id", RequirePermission(config.PermissionAdmin), func(context *gin.Context) {
+		id := context.Param("id")
+
+		issuer.Revoke(id)
+		count := server.DisconnectGuests(id, ShareRevokedReason)
+
+		event := AuditEvent(context, audit.Unshare)
+		event.Guest = id
+		auditor.Log(event)
+
+		context.JSON(http.StatusOK, gin.H{
+			"id":           id,
+			"disconnected": count,
+		})
+	})
+
 	uiGroup := engine.Group("/ui", basicAuth)
-	serveHTML(uiGroup, "/button.html", ButtonHTML, ButtonHTMLPath)
-	serveHTML(uiGroup, "/testkeyboard.html", TestKeyboardHTML, TestKeyboardHTMLPath)
+	serveHTML(uiGroup, "/button.html", ButtonHTML, ButtonHTMLPath, RequirePermission(config.PermissionPower))
+	serveHTML(uiGroup, "/testkeyboard.html", TestKeyboardHTML, TestKeyboardHTMLPath, RequirePermission(config.PermissionInput))
 
 	if conf.VNC.Path != "" {
 		engine.NoRoute(func(context *gin.Context) {
@@ -255,9 +479,28 @@ func main() {
 		})
 	}
 
-	go func() {
-		l.Error().Fatalln(engine.Run(conf.Websocket.Addr))
-	}()
+	if webTLSConfig == nil {
+		go func() {
+			l.Error().Fatalln(engine.Run(conf.Websocket.Addr))
+		}()
+	} else {
+		httpsServer := &http.Server{
+			Addr:      conf.Websocket.Addr,
+			Handler:   engine.Handler(),
+			TLSConfig: webTLSConfig,
+		}
+		go func() {
+			l.Info().Println("https listening on", conf.Websocket.Addr)
+			l.Error().Fatalln(httpsServer.ListenAndServeTLS("", ""))
+		}()
+
+		if conf.Websocket.RedirectAddr != "" {
+			go func() {
+				l.Info().Println("redirect http from", conf.Websocket.RedirectAddr)
+				l.Error().Fatalln(http.ListenAndServe(conf.Websocket.RedirectAddr, RedirectToHTTPS(conf.Websocket.Addr)))
+			}()
+		}
+	}
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ShareTokenQuery is the query of websocket path which carries the share token
+	ShareTokenQuery = "share"
+	// ShareRevokedReason for guests disconnected by revoking their link
+	ShareRevokedReason = "Share link revoked"
+)
+
+// ShareURL returns the noVNC page which connects to the websocket at wsPath with token automatically.
+// The page is under base if it is not nil, otherwise scheme and host are taken from request.
+func ShareURL(request *http.Request, base *url.URL, wsPath, token string, viewOnly bool) string {
+	page := &url.URL{
+		Scheme: "http",
+		Host:   request.Host,
+	}
+	if request.TLS != nil {
+		page.Scheme = "https"
+	}
+	if base != nil {
+		page.Scheme = base.Scheme
+		page.Host = base.Host
+		// for reverse proxies serving under a sub path
+		page.Path = strings.TrimSuffix(base.Path, "/")
+	}
+
+	wsPath = page.Path + "/" + strings.TrimPrefix(wsPath, "/")
+	page.Path += "/vnc.html"
+
+	query := url.Values{
+		"autoconnect": {"true"},
+		// noVNC takes path without leading slash
+		"path": {strings.TrimPrefix(wsPath, "/") + "?" + ShareTokenQuery + "=" + url.QueryEscape(token)},
+	}
+	if viewOnly {
+		query.Set("view_only", "true")
+	}
+	page.RawQuery = query.Encode()
+
+	return page.String()
+}
+
+// RedactShareToken hides the share token in the query of path
+func RedactShareToken(path string) string {
+	p, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// can NOT tell where the token is, drop the whole query
+		return p + "?REDACTED"
+	}
+	if !query.Has(ShareTokenQuery) {
+		return path
+	}
+
+	query.Set(ShareTokenQuery, "REDACTED")
+	return p + "?" + query.Encode()
+}
+
+// LogFormatter is the default formatter of gin, except share tokens are NOT logged
+func LogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		RedactShareToken(param.Path),
+		param.ErrorMessage,
+	)
+}
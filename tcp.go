@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"github.com/allape/openkvm/kvm"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+func TCP2KVMClient(conn net.Conn, timeout time.Duration) *kvm.Client {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// framebuffer updates are large and frequent, latency matters more than packet count
+		_ = tcp.SetNoDelay(true)
+	}
+	client := kvm.NewClient(conn, timeout)
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		client.RemoteAddr = addr.IP.String()
+	} else {
+		client.RemoteAddr = conn.RemoteAddr().String()
+	}
+	return client
+}
+
+// ServeTCP accepts connections of native VNC clients until listener is closed
+func ServeTCP(listener net.Listener, timeout time.Duration, handle func(client *kvm.Client)) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			l.Warn().Println("accept:", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		go func() {
+			// one broken client should NOT crash the whole server
+			defer func() {
+				if r := recover(); r != nil {
+					l.Error().Printf("panic of client from %s: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+					_ = conn.Close()
+				}
+			}()
+			handle(TCP2KVMClient(conn, timeout))
+		}()
+	}
+}
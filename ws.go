@@ -4,6 +4,7 @@ import (
 	"github.com/allape/openkvm/kvm"
 	"github.com/gorilla/websocket"
 	"time"
+	"unicode/utf8"
 )
 
 type WebsocketsKVMClient struct {
@@ -31,6 +32,26 @@ func (w *WebsocketsKVMClient) Close() error {
 	return w.Conn.Close()
 }
 
+// MaxCloseReasonLength control frames are limited to 125 bytes, 2 of them are the close code
+const MaxCloseReasonLength = 123
+
+func (w *WebsocketsKVMClient) CloseWithReason(reason string) error {
+	if len(reason) > MaxCloseReasonLength {
+		// reason must be valid UTF-8, do NOT cut in the middle of a rune
+		end := MaxCloseReasonLength
+		for end > 0 && !utf8.RuneStart(reason[end]) {
+			end--
+		}
+		reason = reason[:end]
+	}
+	_ = w.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		time.Now().Add(time.Second),
+	)
+	return w.Conn.Close()
+}
+
 func Websockets2KVMClient(conn *websocket.Conn, timeout time.Duration) *kvm.Client {
 	return kvm.NewClient(&WebsocketsKVMClient{Conn: conn}, timeout)
 }